@@ -11,6 +11,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
@@ -275,6 +276,90 @@ type AppConfigImageBucket struct {
 	Watermark      string `json:"water_mark"`
 	Quality        int    `json:"quality"`
 	WatermarkAfter int    `json:"watermark_after"`
+	AutoWebP       bool   `json:"auto_webp"`      // serve N.jpg as webp if Accept allows
+	Background     string `json:"background"`     // #rrggbb fill of transparent originals for jpg/webp, default #ffffff
+	ValidateCache  bool   `json:"validate_cache"` // on startup decode cache files, regenerate corrupted
+	WatchSource    bool   `json:"watch_source"`   // fsnotify, purge variants if original changed or deleted
+	MaxBytes       int64  `json:"max_bytes"`      // limit of originals (upload and decode), default 20MB
+	MaxDimension   int    `json:"max_dimension"`  // limit of width and height of originals, default 10000
+	PurgeWebhook   string `json:"purge_webhook"`  // POST json on purge, CDN invalidation
+	DynamicSize    bool   `json:"dynamic_size"`   // serve WxH variants, 300x200.jpg?fit=cover&gravity=north
+	DynamicMax     int    `json:"dynamic_max"`    // limit of WxH width and height, default 2000
+	Resample       string `json:"resample"`       // kernel nearest bilinear catmullrom lanczos3, default approximate bilinear
+	ScaledDecode   bool   `json:"scaled_decode"`  // decode large jpeg at 1/2 1/4 1/8 if variant is small enough
+	Metadata       string `json:"metadata"`       // of variants: strip (default) copyright (EXIF Artist, Copyright) keep (EXIF, XMP)
+	ICC            string `json:"icc"`            // embedded colour profile of originals: convert (default, to sRGB) ignore
+	EmbedSRGB      bool   `json:"embed_srgb"`     // sRGB ICC profile in variants
+
+	CacheMaxBytes        int64 `json:"cache_max_bytes"`        // janitor evicts least recently used variants over it, 0 not limited
+	CacheMaxAge          int   `json:"cache_max_age"`          // sec, janitor removes variants not accessed for it, 0 not limited
+	CacheJanitorInterval int   `json:"cache_janitor_interval"` // sec, default 60
+
+	MaxWidth      int     `json:"max_width"`      // limit of originals, default max_dimension
+	MaxHeight     int     `json:"max_height"`     // limit of originals, default max_dimension
+	MaxMegapixels float64 `json:"max_megapixels"` // limit of width x height of originals, default 100
+
+	AnimationMaxFrames int   `json:"animation_max_frames"` // animated gif originals over limit are served as first frame, default 300
+	AnimationMaxPixels int64 `json:"animation_max_pixels"` // frames x width x height limit, default 100M
+	AnimatedWebP       bool  `json:"animated_webp"`        // N.webp of animated gif originals is animated
+
+	PlaceholderSize   int    `json:"placeholder_size"`   // px of placeholder data uri image, default 20
+	PlaceholderFormat string `json:"placeholder_format"` // of placeholder data uri image: jpg (default) webp png
+
+	SourceStorage AppConfigStorage `json:"source_storage"` // default filesystem dir of source
+	CacheStorage  AppConfigStorage `json:"cache_storage"`  // default filesystem dir of cache
+
+	WatermarkStyle AppConfigImageWatermark `json:"watermark_style"`
+
+	Presets []AppConfigImagePreset `json:"presets"`
+}
+
+// AppConfigStorage backend of bucket originals or cache, s3 is path-style (MinIO, AWS ...),
+// http is an origin server of originals (source only)
+type AppConfigStorage struct {
+	Type      string `json:"type"`       // fs (default) s3 http
+	Endpoint  string `json:"endpoint"`   // http(s)://host:port
+	Region    string `json:"region"`     // default us-east-1
+	Bucket    string `json:"bucket"`     // s3 bucket
+	Prefix    string `json:"prefix"`     // of object keys, "images/"
+	AccessKey string `json:"access_key"` // default env AWS_ACCESS_KEY_ID
+	SecretKey string `json:"secret_key"` // default env AWS_SECRET_ACCESS_KEY
+
+	URL          string   `json:"url"`           // http origin template "https://origin/{id}.jpg"
+	Timeout      int      `json:"timeout"`       // sec, of one origin request, default 10
+	ContentTypes []string `json:"content_types"` // allowed of origin, default image types
+	NotFoundTTL  int      `json:"not_found_ttl"` // sec, origin 404 is cached, default 60
+	Revalidate   int      `json:"revalidate"`    // sec, origin size and mtime are cached, stored copies checked after it, default 60
+	Persist      bool     `json:"persist"`       // store fetched originals in source dir, served from it
+}
+
+// AppConfigImageWatermark style of bucket watermark, text of water_mark or logo
+type AppConfigImageWatermark struct {
+	Logo     string  `json:"logo"`      // png file, drawn instead of text
+	Scale    float64 `json:"scale"`     // logo width relative to variant width 0..1, default logo size
+	Position string  `json:"position"`  // center (default) north south east west north-east ...
+	Margin   int     `json:"margin"`    // px from the edges, or between marks (tile, diagonal)
+	Opacity  float64 `json:"opacity"`   // 0..1, default 1
+	Mode     string  `json:"mode"`      // single (default) tile diagonal
+	Color    string  `json:"color"`     // text #rrggbbaa, default translucent light grey
+	Font     string  `json:"font"`      // ttf or otf file, default embedded Roboto
+	FontSize float64 `json:"font_size"` // px, default variant height/10 in 24..36
+	Rotation float64 `json:"rotation"`  // degrees counter-clockwise, diagonal default 45
+}
+
+// AppConfigImagePreset named variant /image/api/size/:bucket/:id/thumb.jpg
+type AppConfigImagePreset struct {
+	Name      string `json:"name"`      // thumb card hero
+	Width     int    `json:"width"`     // px, 0 by height
+	Height    int    `json:"height"`    // px, 0 by width
+	Fit       string `json:"fit"`       // contain (default) cover fill pad
+	Gravity   string `json:"gravity"`   // cover crop and pad anchor: center (default) north south east west north-east ...
+	PadColor  string `json:"pad_color"` // pad fill #rrggbb or #rrggbbaa, default transparent (png) or background
+	Quality   int    `json:"quality"`   // default bucket quality
+	Format    string `json:"format"`    // jpg webp png gif, only this ext is served; default any
+	Watermark string `json:"watermark"` // auto (default, bucket watermark_after) always never
+
+	Filters []string `json:"filters"` // after resize, in order: grayscale blur[:radius] sharpen[:amount]
 }
 
 func NewImageBucket(name string) *AppConfigImageBucket {
@@ -295,17 +380,27 @@ func (x AppConfigImageBucket) validate() error {
 		return fmt.Errorf("error bucket name is empty")
 	}
 
-	if x.Source == "" {
+	if x.Source == "" && x.SourceStorage.Type != "s3" && (x.SourceStorage.Type != "http" || x.SourceStorage.Persist) {
 		return fmt.Errorf("error bucket source is empty")
 	}
 
-	if x.Cache == "" {
+	if x.Cache == "" && x.CacheStorage.Type != "s3" {
 		return fmt.Errorf("error bucket cache is empty")
 	}
 
 	return nil
 }
 
+// AppConfigImage image generation limits, shared by all buckets
+type AppConfigImage struct {
+	MaxJobs    int `json:"max_jobs"`    // concurrent decode/encode jobs, default NumCPU
+	QueueDepth int `json:"queue_depth"` // jobs waiting for a worker, then 503
+	RetryAfter int `json:"retry_after"` // sec, Retry-After of 503
+
+	DecodeMemoryMB int `json:"decode_memory_mb"` // estimated pixel memory of concurrent decodes, then wait; default 1024, 0 not limited
+	MemoryCacheMB  int `json:"memory_cache_mb"`  // hot variants in memory (all buckets), 0 off (default)
+}
+
 type AppConfigVault struct {
 	VaultAuth map[string]string `json:"auth"` // keyId:keyValue
 }
@@ -336,6 +431,8 @@ type AppConfig struct {
 
 	ImageBuckets []AppConfigImageBucket `json:"image_buckets"`
 
+	Image AppConfigImage `json:"image"`
+
 	// gms
 	HTTPTransport AppConfigHTTPTransport `json:"http_transport"`
 
@@ -384,6 +481,14 @@ func NewAppConfig() *AppConfig {
 
 		ImageBuckets: []AppConfigImageBucket{},
 
+		Image: AppConfigImage{
+			MaxJobs:    runtime.NumCPU(),
+			QueueDepth: 64,
+			RetryAfter: 1,
+
+			DecodeMemoryMB: 1024,
+		},
+
 		HTTPTransport: AppConfigHTTPTransport{},
 
 		HTTPServer: AppConfigHTTPServer{
@@ -493,6 +598,12 @@ func (x *AppConfig) readEnvVar() error {
 
 	reader.String(&x.HTTPServer.SysAPIKey, "sys_api_key", &CmdLine.SysAPIKey)
 
+	reader.Int(&x.Image.MaxJobs, "image_max_jobs", nil)
+	reader.Int(&x.Image.QueueDepth, "image_queue_depth", nil)
+	reader.Int(&x.Image.RetryAfter, "image_retry_after", nil)
+	reader.Int(&x.Image.DecodeMemoryMB, "image_decode_memory_mb", nil)
+	reader.Int(&x.Image.MemoryCacheMB, "image_memory_cache_mb", nil)
+
 	{
 		b := []string{}
 		reader.StringArray(&b, "image_bucket", &CmdLine.ImageBucket)
@@ -7,6 +7,8 @@ const (
 	// DefaultTextLength default size of text field
 	DefaultTextLength = 100
 	ImageSizeNr       = 10
+	// MaxUploadBytes request body limit of upload, bucket limit is checked by service
+	MaxUploadBytes = 100 << 20
 )
 
 const (
@@ -15,4 +17,15 @@ const (
 	PathImagePingDebugAPI = "/image/api/ping"
 
 	PathImageSizeAPI = "/image/api/size/:bucket/:id/:name" //  not work :size.:enc not correct :size:enc
+
+	PathImageInfoAPI = "/image/api/info/:bucket/:id" // GET original metadata
+
+	PathImagePlaceholderAPI = "/image/api/placeholder/:bucket/:id" // GET BlurHash, ThumbHash, tiny data uri
+
+	PathImagePaletteAPI = "/image/api/palette/:bucket/:id" // GET dominant colour, palette
+
+	PathImageSourceAPI = "/image/api/source/:bucket/:id" // PUT POST upload original, DELETE
+
+	PathImageCacheAPI       = "/image/api/cache/:bucket/:id" // DELETE purge variants of id
+	PathImageCacheBucketAPI = "/image/api/cache/:bucket"     // DELETE purge variants of bucket
 )
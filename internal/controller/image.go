@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"errors"
+	"go-image/internal/config/consts"
+	"go-image/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// imageBusy 503 with Retry-After, of service.ErrBusy
+func imageBusy(c echo.Context, appService service.AppService) error {
+
+	retryAfter := max(appService.Config().Image.RetryAfter, 1)
+	c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return c.NoContent(http.StatusServiceUnavailable)
+}
+
+// sourceRejected status of original rejected by bucket limits, 0 if err is other
+func sourceRejected(err error) int {
+
+	switch {
+	case errors.Is(err, service.ErrSourceTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrSourceInvalid):
+		return http.StatusUnprocessableEntity
+	}
+	return 0
+}
+
+// imageSizeURL path of size variant
+func imageSizeURL(bucket string, id string, name string) string {
+
+	return strings.NewReplacer(
+		":bucket", bucket,
+		":id", id,
+		":name", name,
+	).Replace(consts.PathImageSizeAPI)
+}
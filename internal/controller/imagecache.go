@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"fmt"
+	"go-image/internal/config/consts"
+	"go-image/internal/service"
+	"go-image/internal/util/utilhttp"
+	xlog "go-image/internal/util/utillog"
+	"go-image/internal/util/utilstring"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type imageCacheDTO struct {
+	Input struct {
+		Bucket string `param:"bucket"` // from path :bucket/:id
+		ID     string `param:"id"`     // from path, empty for whole bucket
+	}
+}
+
+func (x *imageCacheDTO) validate() (msg string) {
+
+	input := &x.Input
+
+	if len(input.Bucket) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if len(input.ID) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if !utilstring.IsValidID(input.Bucket) {
+		return "-"
+	}
+
+	if input.ID != "" && !utilstring.IsValidID(input.ID) {
+		return "-"
+	}
+
+	return ""
+}
+
+type imagePurgeResponse struct {
+	Purged int `json:"purged"` // removed variants
+}
+
+// ImageCacheController controller
+type ImageCacheController struct {
+	appService service.AppService
+	webCtxt    echo.Context
+	Debug      bool
+}
+
+// NewImageCacheController new controller
+func NewImageCacheController(appService service.AppService, c echo.Context) *ImageCacheController {
+
+	appConfig := appService.Config()
+	return &ImageCacheController{
+		Debug:      appConfig.Debug,
+		appService: appService,
+		webCtxt:    c,
+	}
+}
+
+// Purge variants of id or of whole bucket handler, DELETE
+func (x *ImageCacheController) Purge() error {
+
+	c := x.webCtxt
+	dto := &imageCacheDTO{}
+	input := &dto.Input
+	err := (&echo.DefaultBinder{}).BindPathParams(c, input)
+	if err != nil {
+		return err
+	}
+
+	if msg := dto.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, utilhttp.NewMessage(fmt.Sprintf("validation failed: %v", msg)))
+	}
+
+	srv := x.appService.ImageSize()
+
+	if !srv.HasBucket(input.Bucket) {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	var count int
+	if input.ID == "" {
+		count, err = srv.PurgeBucket(input.Bucket)
+	} else {
+		count, err = srv.PurgeCache(input.Bucket, input.ID)
+	}
+
+	if err != nil {
+		xlog.Error("image cache purge error: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, &imagePurgeResponse{Purged: count})
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"go-image/internal/config/consts"
+	"go-image/internal/service"
+	"go-image/internal/util/utilhttp"
+	xlog "go-image/internal/util/utillog"
+	"go-image/internal/util/utilstring"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type imageInfoDTO struct {
+	Input struct {
+		Bucket string `param:"bucket"` // from path :bucket/:id
+		ID     string `param:"id"`     // from path
+		Debug  bool   `query:"debug"`  // variant crop rects, decodes the original
+	}
+}
+
+func (x *imageInfoDTO) validate() (msg string) {
+
+	input := &x.Input
+
+	if len(input.Bucket) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if len(input.ID) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if !utilstring.IsValidID(input.Bucket) {
+		return "-"
+	}
+
+	if !utilstring.IsValidID(input.ID) {
+		return "-"
+	}
+
+	return ""
+}
+
+type imageVariantResponse struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Cached bool   `json:"cached"`
+
+	Crop *imageCropResponse `json:"crop,omitempty"` // debug
+}
+
+// imageCropResponse source rect of variant
+type imageCropResponse struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type imageInfoResponse struct {
+	Bucket      string                    `json:"bucket"`
+	ID          string                    `json:"id"`
+	Format      string                    `json:"format"`
+	Width       int                       `json:"width"`
+	Height      int                       `json:"height"`
+	Size        int64                     `json:"size"`
+	ModTime     time.Time                 `json:"mtime"`
+	Orientation int                       `json:"orientation"`
+	ColorModel  string                    `json:"color_model"`
+	Placeholder *imagePlaceholderResponse `json:"placeholder"`
+	Variants    []imageVariantResponse    `json:"variants"`
+}
+
+// ImageInfoController controller
+type ImageInfoController struct {
+	appService service.AppService
+	webCtxt    echo.Context
+	Debug      bool
+}
+
+// NewImageInfoController new controller
+func NewImageInfoController(appService service.AppService, c echo.Context) *ImageInfoController {
+
+	appConfig := appService.Config()
+	return &ImageInfoController{
+		Debug:      appConfig.Debug,
+		appService: appService,
+		webCtxt:    c,
+	}
+}
+
+// ImageInfo handler
+func (x *ImageInfoController) ImageInfo() error {
+
+	c := x.webCtxt
+	dto := &imageInfoDTO{}
+	input := &dto.Input
+	err := c.Bind(input)
+	if err != nil {
+		return err
+	}
+
+	if msg := dto.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, utilhttp.NewMessage(fmt.Sprintf("validation failed: %v", msg)))
+	}
+
+	srv := x.appService.ImageSize()
+
+	if !srv.HasBucket(input.Bucket) {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	info, err := srv.Info(input.Bucket, input.ID, input.Debug)
+
+	if errors.Is(err, service.ErrBusy) {
+		return imageBusy(c, x.appService)
+	}
+
+	if status := sourceRejected(err); status != 0 {
+		return c.JSON(status, utilhttp.NewMessage(err.Error()))
+	}
+
+	if err != nil {
+		xlog.Error("image info error: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if info == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	res := &imageInfoResponse{
+		Bucket:      info.Bucket,
+		ID:          info.ID,
+		Format:      info.Format,
+		Width:       info.Width,
+		Height:      info.Height,
+		Size:        info.Size,
+		ModTime:     info.ModTime.UTC(),
+		Orientation: info.Orientation,
+		ColorModel:  info.ColorModel,
+		Placeholder: newImagePlaceholderResponse(info.Placeholder),
+		Variants:    []imageVariantResponse{},
+	}
+
+	for _, v := range info.Variants {
+		item := imageVariantResponse{
+			Name:   v.Name,
+			URL:    imageSizeURL(info.Bucket, info.ID, v.Name),
+			Width:  v.Width,
+			Height: v.Height,
+			Cached: v.Cached,
+		}
+		if input.Debug {
+			item.Crop = &imageCropResponse{X: v.Crop.Min.X, Y: v.Crop.Min.Y, Width: v.Crop.Dx(), Height: v.Crop.Dy()}
+		}
+		res.Variants = append(res.Variants, item)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"go-image/internal/config/consts"
+	"go-image/internal/service"
+	"go-image/internal/util/utilhttp"
+	xlog "go-image/internal/util/utillog"
+	"go-image/internal/util/utilstring"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type imagePaletteDTO struct {
+	Input struct {
+		Bucket string `param:"bucket"` // from path :bucket/:id
+		ID     string `param:"id"`     // from path
+	}
+}
+
+func (x *imagePaletteDTO) validate() (msg string) {
+
+	input := &x.Input
+
+	if len(input.Bucket) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if len(input.ID) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if !utilstring.IsValidID(input.Bucket) {
+		return "-"
+	}
+
+	if !utilstring.IsValidID(input.ID) {
+		return "-"
+	}
+
+	return ""
+}
+
+// imagePaletteResponse colours as #rrggbb
+type imagePaletteResponse struct {
+	Dominant string   `json:"dominant"`
+	Palette  []string `json:"palette"` // most frequent first
+}
+
+// ImagePaletteController controller
+type ImagePaletteController struct {
+	appService service.AppService
+	webCtxt    echo.Context
+	Debug      bool
+}
+
+// NewImagePaletteController new controller
+func NewImagePaletteController(appService service.AppService, c echo.Context) *ImagePaletteController {
+
+	appConfig := appService.Config()
+	return &ImagePaletteController{
+		Debug:      appConfig.Debug,
+		appService: appService,
+		webCtxt:    c,
+	}
+}
+
+// ImagePalette handler
+func (x *ImagePaletteController) ImagePalette() error {
+
+	c := x.webCtxt
+	dto := &imagePaletteDTO{}
+	input := &dto.Input
+	err := c.Bind(input)
+	if err != nil {
+		return err
+	}
+
+	if msg := dto.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, utilhttp.NewMessage(fmt.Sprintf("validation failed: %v", msg)))
+	}
+
+	srv := x.appService.ImageSize()
+
+	if !srv.HasBucket(input.Bucket) {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	p, err := srv.Palette(input.Bucket, input.ID)
+
+	if errors.Is(err, service.ErrBusy) {
+		return imageBusy(c, x.appService)
+	}
+
+	if status := sourceRejected(err); status != 0 {
+		return c.JSON(status, utilhttp.NewMessage(err.Error()))
+	}
+
+	if err != nil {
+		xlog.Error("image palette error: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if p == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	return c.JSON(http.StatusOK, &imagePaletteResponse{Dominant: p.Dominant, Palette: p.Palette})
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"go-image/internal/config/consts"
+	"go-image/internal/service"
+	"go-image/internal/util/utilhttp"
+	xlog "go-image/internal/util/utillog"
+	"go-image/internal/util/utilstring"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type imagePlaceholderDTO struct {
+	Input struct {
+		Bucket string `param:"bucket"` // from path :bucket/:id
+		ID     string `param:"id"`     // from path
+	}
+}
+
+func (x *imagePlaceholderDTO) validate() (msg string) {
+
+	input := &x.Input
+
+	if len(input.Bucket) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if len(input.ID) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if !utilstring.IsValidID(input.Bucket) {
+		return "-"
+	}
+
+	if !utilstring.IsValidID(input.ID) {
+		return "-"
+	}
+
+	return ""
+}
+
+// imagePlaceholderResponse low quality placeholders
+type imagePlaceholderResponse struct {
+	BlurHash  string `json:"blurhash"`
+	ThumbHash string `json:"thumbhash"` // base64
+	DataURI   string `json:"data_uri"`
+	Width     int    `json:"width"` // of data_uri image
+	Height    int    `json:"height"`
+}
+
+func newImagePlaceholderResponse(p *service.PlaceholderInfo) *imagePlaceholderResponse {
+
+	if p == nil {
+		return nil
+	}
+
+	return &imagePlaceholderResponse{
+		BlurHash:  p.BlurHash,
+		ThumbHash: p.ThumbHash,
+		DataURI:   p.DataURI,
+		Width:     p.Width,
+		Height:    p.Height,
+	}
+}
+
+// ImagePlaceholderController controller
+type ImagePlaceholderController struct {
+	appService service.AppService
+	webCtxt    echo.Context
+	Debug      bool
+}
+
+// NewImagePlaceholderController new controller
+func NewImagePlaceholderController(appService service.AppService, c echo.Context) *ImagePlaceholderController {
+
+	appConfig := appService.Config()
+	return &ImagePlaceholderController{
+		Debug:      appConfig.Debug,
+		appService: appService,
+		webCtxt:    c,
+	}
+}
+
+// ImagePlaceholder handler
+func (x *ImagePlaceholderController) ImagePlaceholder() error {
+
+	c := x.webCtxt
+	dto := &imagePlaceholderDTO{}
+	input := &dto.Input
+	err := c.Bind(input)
+	if err != nil {
+		return err
+	}
+
+	if msg := dto.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, utilhttp.NewMessage(fmt.Sprintf("validation failed: %v", msg)))
+	}
+
+	srv := x.appService.ImageSize()
+
+	if !srv.HasBucket(input.Bucket) {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	p, err := srv.Placeholder(input.Bucket, input.ID)
+
+	if errors.Is(err, service.ErrBusy) {
+		return imageBusy(c, x.appService)
+	}
+
+	if status := sourceRejected(err); status != 0 {
+		return c.JSON(status, utilhttp.NewMessage(err.Error()))
+	}
+
+	if err != nil {
+		xlog.Error("image placeholder error: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if p == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	return c.JSON(http.StatusOK, newImagePlaceholderResponse(p))
+}
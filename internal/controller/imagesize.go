@@ -3,31 +3,48 @@ package controller
 // Handler web req handler
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"go-image/internal/config/consts"
 	"go-image/internal/service"
 	"go-image/internal/util/utilhttp"
 	xlog "go-image/internal/util/utillog"
 	"go-image/internal/util/utilstring"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
+var imageExts = []string{".jpg", ".webp", ".png", ".gif"}
+
+// dynamicSizeRe 300x200, options from query or path 300x200-cover-north
+var dynamicSizeRe = regexp.MustCompile(`^[0-9]{1,5}x[0-9]{1,5}(-[a-z0-9]{1,10}){0,3}$`)
+
+// queryTokenRe fit gravity pad, "south-east" "#000000"
+var queryTokenRe = regexp.MustCompile(`^#?[a-z0-9-]{1,10}$`)
+
 type imageSizeDTO struct {
 	Input struct {
 		Bucket string `param:"bucket"` // from path :bucket/:id/:size
 		ID     string `param:"id"`     // from path
 		Name   string `param:"name"`   // from path
+
+		Fit     string `query:"fit"`     // WxH: contain cover fill pad
+		Gravity string `query:"gravity"` // WxH: center north south-east ...
+		Pad     string `query:"pad"`     // WxH: pad color rrggbb
 	}
 	Data struct {
-		Size int
-		Ext  string
-		Name string
+		Variant string // "2" size number, preset name or "300x200-cover-north"
+		Ext     string
+		Name    string
 	}
 }
 
@@ -35,25 +52,43 @@ func (x *imageSizeDTO) validate() (msg string) {
 
 	input := &x.Input
 	data := &x.Data
-	var err error
 	{
 
 		// !!! input from user filter
 		data.Ext = filepath.Ext(input.Name)
-		if data.Ext != ".jpg" {
-			return "Ext only .jpg"
+		if !slices.Contains(imageExts, data.Ext) {
+			return "Ext only .jpg .webp .png .gif"
 		}
 
 		// !!! input from user filter
-		if data.Size, err = strconv.Atoi(strings.TrimSuffix(input.Name, data.Ext)); err != nil {
-			return "Name format 1.jpg"
-		}
-
-		if data.Size < 1 || data.Size > consts.ImageSizeNr {
-			return "-"
+		base := strings.TrimSuffix(input.Name, data.Ext)
+		if size, err := strconv.Atoi(base); err == nil {
+			if size < 1 || size > consts.ImageSizeNr {
+				return "-"
+			}
+			data.Variant = strconv.Itoa(size)
+		} else if dynamicSizeRe.MatchString(base) {
+			data.Variant = base
+			for _, v := range []string{input.Fit, input.Gravity, input.Pad} {
+				if v == "" {
+					continue
+				}
+				v = strings.ToLower(v)
+				if !queryTokenRe.MatchString(v) {
+					return "Query fit, gravity or pad not valid"
+				}
+				v = strings.TrimPrefix(v, "#")
+				v = strings.ReplaceAll(v, "-", "") // south-east
+				data.Variant += "-" + v
+			}
+		} else {
+			if len(base) > consts.DefaultTextLength || !utilstring.IsValidID(base) {
+				return "Name format 1.jpg, preset.jpg or 300x200.jpg"
+			}
+			data.Variant = base
 		}
 
-		data.Name = fmt.Sprintf("%v%v", data.Size, data.Ext) // re-create
+		data.Name = fmt.Sprintf("%v%v", data.Variant, data.Ext) // re-create
 	}
 
 	if len(input.Bucket) > consts.DefaultTextLength {
@@ -111,7 +146,15 @@ func (x *ImageSizeController) ImageSize() error {
 
 	srv := x.appService.ImageSize()
 
-	img, err := srv.Image(input.Bucket, input.ID, data.Size, data.Ext)
+	img, err := srv.Image(input.Bucket, input.ID, data.Variant, data.Ext, c.Request().Header.Get(echo.HeaderAccept))
+
+	if errors.Is(err, service.ErrBusy) {
+		return imageBusy(c, x.appService)
+	}
+
+	if status := sourceRejected(err); status != 0 {
+		return c.JSON(status, utilhttp.NewMessage(err.Error()))
+	}
 
 	if err != nil {
 		xlog.Error("image size error: %v", err)
@@ -125,27 +168,39 @@ func (x *ImageSizeController) ImageSize() error {
 		// 	// c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename="+img.Name)
 		// }
 
-		if img.Size > 0 {
-			// fmt.Sprint(img.Size)
-			// string(rune(img.Size)) NOT WORK emoji chars
+		header := c.Response().Header()
 
-			c.Response().Header().Set(echo.HeaderContentLength, strconv.FormatInt(img.Size, 10))
-		}
+		header.Set(`Cache-Control`, "public,max-age=2592000,immutable")
 
-		c.Response().Header().Set(`Cache-Control`, "public,max-age=2592000,immutable")
+		if img.Vary != "" {
+			header.Set(echo.HeaderVary, img.Vary)
+		}
 
-		if len(img.Data) > 0 {
-			return c.Blob(http.StatusOK, img.Mime, img.Data)
+		if img.ETag != "" {
+			header.Set("ETag", img.ETag)
 		}
 
-		if len(img.File) > 0 {
+		// no sniffing in ServeContent
+		header.Set(echo.HeaderContentType, img.Mime)
+
+		var content io.ReadSeeker
+
+		if len(img.Data) > 0 {
+			content = bytes.NewReader(img.Data)
+		} else if len(img.File) > 0 {
 			stream, err := os.Open(img.File)
 			if err != nil {
 				xlog.Error("file open error: %v", img.File)
 				return c.NoContent(http.StatusInternalServerError)
 			}
 			defer stream.Close()
-			return c.Stream(http.StatusOK, img.Mime, stream)
+			content = stream
+		}
+
+		if content != nil {
+			// conditional GET (If-None-Match, If-Modified-Since), HEAD, Range
+			http.ServeContent(c.Response(), c.Request(), img.Name, img.ModTime, content)
+			return nil
 		}
 
 	}
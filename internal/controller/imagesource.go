@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"go-image/internal/config/consts"
+	"go-image/internal/service"
+	"go-image/internal/util/utilhttp"
+	xlog "go-image/internal/util/utillog"
+	"go-image/internal/util/utilstring"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type imageSourceDTO struct {
+	Input struct {
+		Bucket string `param:"bucket"` // from path :bucket/:id
+		ID     string `param:"id"`     // from path
+	}
+}
+
+func (x *imageSourceDTO) validate() (msg string) {
+
+	input := &x.Input
+
+	if len(input.Bucket) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if len(input.ID) > consts.DefaultTextLength {
+		return "-"
+	}
+
+	if !utilstring.IsValidID(input.Bucket) {
+		return "-"
+	}
+
+	if !utilstring.IsValidID(input.ID) {
+		return "Id format a-z A-Z 0-9 _ -"
+	}
+
+	return ""
+}
+
+type imageSourceResponse struct {
+	Bucket   string   `json:"bucket"`
+	ID       string   `json:"id"`
+	Format   string   `json:"format"`
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	Size     int64    `json:"size"`
+	Variants []string `json:"variants"` // urls
+}
+
+// ImageSourceController controller
+type ImageSourceController struct {
+	appService service.AppService
+	webCtxt    echo.Context
+	Debug      bool
+}
+
+// NewImageSourceController new controller
+func NewImageSourceController(appService service.AppService, c echo.Context) *ImageSourceController {
+
+	appConfig := appService.Config()
+	return &ImageSourceController{
+		Debug:      appConfig.Debug,
+		appService: appService,
+		webCtxt:    c,
+	}
+}
+
+// body raw image or multipart field "file"
+func (x *ImageSourceController) body() (io.ReadCloser, error) {
+
+	c := x.webCtxt
+	req := c.Request()
+
+	if !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		return req.Body, nil
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+
+	return file.Open()
+}
+
+// ImageSource upload handler, PUT POST
+func (x *ImageSourceController) ImageSource() error {
+
+	c := x.webCtxt
+	dto := &imageSourceDTO{}
+	input := &dto.Input
+	// path only, body is the image
+	err := (&echo.DefaultBinder{}).BindPathParams(c, input)
+	if err != nil {
+		return err
+	}
+
+	if msg := dto.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, utilhttp.NewMessage(fmt.Sprintf("validation failed: %v", msg)))
+	}
+
+	srv := x.appService.ImageSize()
+
+	if !srv.HasBucket(input.Bucket) {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, consts.MaxUploadBytes)
+
+	body, err := x.body()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, utilhttp.NewMessage(fmt.Sprintf("validation failed: %v", err)))
+	}
+	defer body.Close()
+
+	src, err := srv.PutSource(input.Bucket, input.ID, body)
+
+	{
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case err == nil:
+		case errors.As(err, &maxBytesErr):
+			return c.JSON(http.StatusRequestEntityTooLarge, utilhttp.NewMessage(err.Error()))
+		case sourceRejected(err) != 0:
+			return c.JSON(sourceRejected(err), utilhttp.NewMessage(err.Error()))
+		case errors.Is(err, service.ErrSourceReadOnly):
+			return c.JSON(http.StatusMethodNotAllowed, utilhttp.NewMessage(err.Error()))
+		case errors.Is(err, service.ErrBusy):
+			return imageBusy(c, x.appService)
+		default:
+			xlog.Error("image source error: %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	res := &imageSourceResponse{
+		Bucket:   src.Bucket,
+		ID:       src.ID,
+		Format:   src.Format,
+		Width:    src.Width,
+		Height:   src.Height,
+		Size:     src.Size,
+		Variants: []string{},
+	}
+
+	for _, name := range src.Names {
+		res.Variants = append(res.Variants, imageSizeURL(src.Bucket, src.ID, name))
+	}
+
+	status := http.StatusOK
+	if src.Created {
+		status = http.StatusCreated
+	}
+
+	return c.JSON(status, res)
+}
+
+// Delete original and its variants handler, DELETE
+func (x *ImageSourceController) Delete() error {
+
+	c := x.webCtxt
+	dto := &imageSourceDTO{}
+	input := &dto.Input
+	err := (&echo.DefaultBinder{}).BindPathParams(c, input)
+	if err != nil {
+		return err
+	}
+
+	if msg := dto.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, utilhttp.NewMessage(fmt.Sprintf("validation failed: %v", msg)))
+	}
+
+	srv := x.appService.ImageSize()
+
+	if !srv.HasBucket(input.Bucket) {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	found, count, err := srv.DeleteSource(input.Bucket, input.ID)
+	if err != nil {
+		xlog.Error("image source delete error: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if !found {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	return c.JSON(http.StatusOK, &imagePurgeResponse{Purged: count})
+}
@@ -2,6 +2,7 @@
 package router
 
 import (
+	"crypto/subtle"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -23,6 +24,16 @@ func Init(e *echo.Echo, appService service.AppService) {
 
 	initImageSizeController(e, appService)
 
+	initImageInfoController(e, appService)
+
+	initImagePlaceholderController(e, appService)
+
+	initImagePaletteController(e, appService)
+
+	initImageSourceController(e, appService)
+
+	initImageCacheController(e, appService)
+
 	initSys(e, appService)
 }
 func initSys(e *echo.Echo, appService service.AppService) {
@@ -119,14 +130,135 @@ func initImageSizeController(e *echo.Echo, appService service.AppService) {
 		return controller.NewImageSizeController(appService, c)
 	}
 
-	e.GET(consts.PathImageSizeAPI, func(c echo.Context) error {
+	handler := func(c echo.Context) error {
 
 		return factory(c).ImageSize()
 
-	})
+	}
+
+	e.GET(consts.PathImageSizeAPI, handler)
+	e.HEAD(consts.PathImageSizeAPI, handler)
 
 	//
 
 }
 
 /////////////////////////////////////////////////////
+
+func initImageInfoController(e *echo.Echo, appService service.AppService) {
+
+	factory := func(c echo.Context) *controller.ImageInfoController {
+		return controller.NewImageInfoController(appService, c)
+	}
+
+	e.GET(consts.PathImageInfoAPI, func(c echo.Context) error {
+
+		return factory(c).ImageInfo()
+
+	})
+}
+
+func initImagePlaceholderController(e *echo.Echo, appService service.AppService) {
+
+	factory := func(c echo.Context) *controller.ImagePlaceholderController {
+		return controller.NewImagePlaceholderController(appService, c)
+	}
+
+	e.GET(consts.PathImagePlaceholderAPI, func(c echo.Context) error {
+
+		return factory(c).ImagePlaceholder()
+
+	})
+}
+
+func initImagePaletteController(e *echo.Echo, appService service.AppService) {
+
+	factory := func(c echo.Context) *controller.ImagePaletteController {
+		return controller.NewImagePaletteController(appService, c)
+	}
+
+	e.GET(consts.PathImagePaletteAPI, func(c echo.Context) error {
+
+		return factory(c).ImagePalette()
+
+	})
+}
+
+// imageAPIAuthMW Authorization: Bearer <key>, key from vault auth, nil if no keys
+func imageAPIAuthMW(appService service.AppService) echo.MiddlewareFunc {
+
+	keys := []string{}
+	for _, v := range appService.Config().Vault.VaultAuth {
+		if v != "" {
+			keys = append(keys, v)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		KeyLookup: "header:Authorization",
+		Validator: func(key string, c echo.Context) (bool, error) {
+			for _, v := range keys {
+				if subtle.ConstantTimeCompare([]byte(key), []byte(v)) == 1 {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+	})
+}
+
+func initImageSourceController(e *echo.Echo, appService service.AppService) {
+
+	authMW := imageAPIAuthMW(appService)
+
+	if authMW == nil {
+		xlog.Warn("image source api disabled: vault auth is empty")
+		return
+	}
+
+	factory := func(c echo.Context) *controller.ImageSourceController {
+		return controller.NewImageSourceController(appService, c)
+	}
+
+	handler := func(c echo.Context) error {
+
+		return factory(c).ImageSource()
+
+	}
+
+	e.PUT(consts.PathImageSourceAPI, handler, authMW)
+	e.POST(consts.PathImageSourceAPI, handler, authMW)
+
+	e.DELETE(consts.PathImageSourceAPI, func(c echo.Context) error {
+
+		return factory(c).Delete()
+
+	}, authMW)
+}
+
+func initImageCacheController(e *echo.Echo, appService service.AppService) {
+
+	authMW := imageAPIAuthMW(appService)
+
+	if authMW == nil {
+		xlog.Warn("image cache api disabled: vault auth is empty")
+		return
+	}
+
+	factory := func(c echo.Context) *controller.ImageCacheController {
+		return controller.NewImageCacheController(appService, c)
+	}
+
+	handler := func(c echo.Context) error {
+
+		return factory(c).Purge()
+
+	}
+
+	e.DELETE(consts.PathImageCacheAPI, handler, authMW)
+	e.DELETE(consts.PathImageCacheBucketAPI, handler, authMW)
+}
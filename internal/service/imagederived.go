@@ -0,0 +1,115 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-image/internal/util/utilstorage"
+	"go-image/internal/util/utilstring"
+	"path/filepath"
+)
+
+const derivedExt = ".json" // id#placeholder.json
+
+// derived data of original (placeholder, palette), cached as json like variants:
+// spec.Name is the cache name, spec.Key makes it stale; create runs in the pool
+// on first call, res is filled from the cache; found false if no original
+func (x *bucketHandler) derived(id string, spec *variantSpec, create func(data []byte) (any, error), res any) (found bool, err error) {
+
+	{
+		if !utilstring.IsValidID(id) {
+			return false, fmt.Errorf("error image id not valid")
+		}
+		id = filepath.Clean(id) //
+	}
+
+	source, err := x.findSource(id)
+	if err != nil {
+		return false, err
+	}
+	if source == nil {
+		return false, nil
+	}
+
+	cacheFile := x.cacheFile(id, spec.Name, derivedExt)
+
+	if !x.cacheReady(cacheFile, source, spec) {
+		// one job per cache file, concurrent requests wait for it
+		key := fmt.Sprintf("%s/%s#%s%s", x.Name, id, spec.Name, derivedExt)
+
+		_, err, _ := x.flight.Do(key, func() (any, error) {
+			source, err := x.findSource(id)
+			if err != nil {
+				return nil, err
+			}
+			if x.cacheReady(cacheFile, source, spec) {
+				return nil, nil
+			}
+			return nil, x.pool.Do(func() error {
+				return x.createDerived(id, spec, create)
+			})
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	data, err := x.cache.Get(cacheFile)
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(data, res)
+}
+
+// derivedCached fills res from the cache if created from source, nothing is created; false if not cached
+func (x *bucketHandler) derivedCached(id string, source *utilstorage.Info, spec *variantSpec, res any) bool {
+
+	cacheFile := x.cacheFile(id, spec.Name, derivedExt)
+	if !metaFresh(x.readCacheMeta(cacheFile), source, spec) {
+		return false
+	}
+
+	data, err := x.cache.Get(cacheFile)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(data, res) == nil
+}
+
+func (x *bucketHandler) createDerived(id string, spec *variantSpec, create func(data []byte) (any, error)) error {
+
+	cacheFile := x.cacheFile(id, spec.Name, derivedExt)
+
+	sourceFile, err := x.sourceFile(id)
+	if err != nil {
+		return err
+	}
+	if sourceFile == "" {
+		return fmt.Errorf("error image source not exists: %v", id)
+	}
+
+	info, data, release, err := x.readSource(sourceFile)
+	if err != nil {
+		return err
+	}
+	meta := newCacheMeta(sourceFile, info, data)
+	meta.Variant = spec.Key
+
+	v, err := create(data)
+	release()
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	err = x.cache.Put(cacheFile, data)
+	if err != nil {
+		return err
+	}
+	// meta last, crash before it means stale
+	return x.writeCacheMeta(cacheFile, meta)
+}
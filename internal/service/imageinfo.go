@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"go-image/internal/util/utilexif"
+	"go-image/internal/util/utilimage"
+	"go-image/internal/util/utilstorage"
+	"go-image/internal/util/utilstring"
+	"image"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+type VariantInfo struct {
+	Name   string // 1.jpg
+	Width  int
+	Height int
+	Cached bool
+	Crop   image.Rectangle // debug, source rect incl. smart crop
+}
+
+type SourceInfo struct {
+	Bucket      string
+	ID          string
+	Format      string
+	Width       int // stored, before Orientation
+	Height      int
+	Size        int64
+	ModTime     time.Time
+	Orientation int // EXIF 1..8
+	ColorModel  string
+	Placeholder *PlaceholderInfo
+	Variants    []VariantInfo
+}
+
+// info header only, no pixel decoding unless debug (variant crop rects)
+func (x *bucketHandler) info(id string, debug bool) (res *SourceInfo, err error) {
+
+	{
+		if !utilstring.IsValidID(id) {
+			return nil, fmt.Errorf("error image id not valid")
+		}
+		id = filepath.Clean(id) //
+	}
+
+	stat, err := x.findSource(id)
+	if err != nil {
+		return nil, err
+	}
+	if stat == nil {
+		return nil, nil
+	}
+	sourceFile := stat.Key
+
+	// header only, of remote storage just the read bytes are transferred
+	f, err := x.source.Open(sourceFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := utilstorage.NewSeeker(f, x.MaxBytes)
+
+	cfg, format, err := image.DecodeConfig(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	orientation := utilexif.OrientationNormal
+	{
+		if _, err := r.Seek(0, io.SeekStart); err == nil {
+			orientation, _ = utilexif.Orientation(r) // bad exif is not an error of image
+		}
+	}
+
+	res = &SourceInfo{
+		Bucket:      x.Name,
+		ID:          id,
+		Format:      format,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+		Size:        stat.Size,
+		ModTime:     stat.ModTime,
+		Orientation: orientation,
+		ColorModel:  utilimage.ColorModelName(cfg.ColorModel),
+		Variants:    []VariantInfo{},
+	}
+
+	res.Placeholder = x.placeholderCached(id, stat) // created by the placeholder endpoint only
+
+	var img image.Image
+	if debug {
+		err = x.pool.Do(func() error {
+			_, data, release, err := x.readSource(sourceFile)
+			if err != nil {
+				return err
+			}
+			defer release()
+			img, err = utilimage.Decode(data) // oriented
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// variants are of oriented source
+	width, height := utilimage.OrientedSize(cfg.Width, cfg.Height, orientation)
+
+	// one listing and the source lookup above, meta read of existing only;
+	// a touched original is not cached until the next request re-hashes it
+	cached := map[string]bool{}
+	{
+		list, err := x.cache.List(x.subDir(id) + "/" + id + "#")
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range list {
+			cached[f.Key] = f.Size > 0
+		}
+	}
+
+	for _, spec := range x.variants() {
+		fit := utilimage.FitDims(width, height, &spec.Transform)
+		crop := image.Rectangle{}
+		if img != nil {
+			crop = utilimage.CropRect(img, &spec.Transform)
+		}
+		for _, ext := range spec.exts() {
+			cacheFile := x.cacheFile(id, spec.Name, ext)
+			res.Variants = append(res.Variants, VariantInfo{
+				Name:   spec.Name + ext,
+				Width:  fit.Width,
+				Height: fit.Height,
+				Cached: cached[cacheFile] && metaFresh(x.readCacheMeta(cacheFile), stat, spec),
+				Crop:   crop,
+			})
+		}
+	}
+
+	return res, nil
+}
+
+func (x *defaultImageSizeSrv) Info(bucket string, id string, debug bool) (*SourceInfo, error) {
+
+	h, err := x.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.info(id, debug)
+}
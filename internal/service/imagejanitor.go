@@ -0,0 +1,189 @@
+package service
+
+import (
+	"errors"
+	xlog "go-image/internal/util/utillog"
+	"go-image/internal/util/utilstorage"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultCacheJanitorInterval = 60  // sec
+	cacheLowWater               = 0.9 // of cache_max_bytes, eviction stops below
+)
+
+// cache eviction reasons, metric label
+const (
+	evictAge  = "age"  // not accessed for cache_max_age
+	evictSize = "size" // least recently used over cache_max_bytes
+)
+
+var (
+	metricCacheBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "image_cache_bytes",
+		Help: "Size of cached variants on disk (with meta), as of last janitor run",
+	}, []string{"bucket"})
+
+	metricCacheEvicted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_cache_evicted_total",
+		Help: "Number of cached variants removed by the janitor",
+	}, []string{"bucket", "reason"})
+)
+
+// cacheAccess last access of cache files, kept in memory and flushed by the janitor
+// as mtime of the meta file (not read otherwise), so atime is not needed and survives restarts;
+// storage without Touch (s3) rewrites the meta
+type cacheAccess struct {
+	mu    sync.Mutex
+	times map[string]time.Time // cache file
+}
+
+// touch cache file accessed now, no-op if no janitor
+func (x *bucketHandler) touch(cacheFile string) {
+
+	a := x.access
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.times[cacheFile] = time.Now()
+	a.mu.Unlock()
+}
+
+// flushAccess access times to meta files, cleared
+func (x *bucketHandler) flushAccess() {
+
+	a := x.access
+	a.mu.Lock()
+	times := a.times
+	a.times = map[string]time.Time{}
+	a.mu.Unlock()
+
+	toucher, _ := x.cache.(utilstorage.Toucher)
+
+	for f, t := range times {
+		var err error
+		if toucher != nil {
+			err = toucher.Touch(f+metaExt, t)
+		} else if meta := x.readCacheMeta(f); meta != nil {
+			err = x.writeCacheMeta(f, meta)
+		}
+		if err != nil && !errors.Is(err, utilstorage.ErrNotExist) {
+			xlog.Error("bucket %v cache access %v: %v", x.Name, f, err)
+		}
+	}
+}
+
+// cacheJanitor remove cache files not accessed for CacheMaxAge,
+// then least recently used down to low water if over CacheMaxBytes
+func (x *bucketHandler) cacheJanitor() {
+
+	ticker := time.NewTicker(time.Duration(x.JanitorInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := x.cleanCache(); err != nil {
+			xlog.Error("bucket %v cache janitor: %v", x.Name, err)
+		}
+		<-ticker.C
+	}
+}
+
+type cacheEntry struct {
+	key    string // cache file
+	size   int64  // with meta
+	access time.Time
+}
+
+func (x *bucketHandler) cleanCache() error {
+
+	x.flushAccess()
+
+	entries := []*cacheEntry{}
+	metas := map[string]*utilstorage.Info{}
+	total := int64(0)
+
+	files, err := x.cache.List("")
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if !strings.Contains(f.Name(), "#") {
+			continue
+		}
+		total += f.Size
+		if strings.HasSuffix(f.Key, metaExt) {
+			metas[strings.TrimSuffix(f.Key, metaExt)] = f
+			continue
+		}
+		entries = append(entries, &cacheEntry{key: f.Key, size: f.Size, access: f.ModTime})
+	}
+
+	for _, e := range entries {
+		if m := metas[e.key]; m != nil {
+			e.size += m.Size
+			if m.ModTime.After(e.access) {
+				e.access = m.ModTime
+			}
+			delete(metas, e.key)
+		}
+	}
+
+	// orphans of removed variants (validate, crash), not counted as evicted
+	for _, m := range metas {
+		if err := x.cache.Delete(m.Key); err != nil {
+			xlog.Error("bucket %v remove %v: %v", x.Name, m.Key, err)
+			continue
+		}
+		total -= m.Size
+	}
+
+	remove := func(e *cacheEntry, reason string) {
+		for _, f := range []string{e.key, e.key + metaExt} {
+			if err := x.cache.Delete(f); err != nil {
+				xlog.Error("bucket %v remove %v: %v", x.Name, f, err)
+			}
+		}
+		if id, variant, ext, ok := x.parseCacheName(path.Base(e.key)); ok {
+			x.memoryDelete(x.memoryKey(id, variant, ext))
+		}
+		total -= e.size
+		metricCacheEvicted.WithLabelValues(x.Name, reason).Inc()
+	}
+
+	if x.CacheMaxAge > 0 {
+		oldest := time.Now().Add(-time.Duration(x.CacheMaxAge) * time.Second)
+		entries = slices.DeleteFunc(entries, func(e *cacheEntry) bool {
+			if e.access.Before(oldest) {
+				remove(e, evictAge)
+				return true
+			}
+			return false
+		})
+	}
+
+	if x.CacheMaxBytes > 0 && total > x.CacheMaxBytes {
+		slices.SortFunc(entries, func(a, b *cacheEntry) int {
+			return a.access.Compare(b.access)
+		})
+		lowWater := int64(float64(x.CacheMaxBytes) * cacheLowWater)
+		for _, e := range entries {
+			if total <= lowWater {
+				break
+			}
+			remove(e, evictSize)
+		}
+	}
+
+	metricCacheBytes.WithLabelValues(x.Name).Set(float64(total))
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-image/internal/util/utilimage"
+	"go-image/internal/util/utilpool"
+	"go-image/internal/util/utilstorage"
+	"image"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultSourceMaxMegapixels = 100
+
+// rejection reasons of originals, metric label
+const (
+	rejectBytes     = "bytes"     // file size over max_bytes
+	rejectDimension = "dimension" // width or height over limit
+	rejectPixels    = "pixels"    // width x height over max_megapixels
+	rejectMemory    = "memory"    // decode estimate over whole decode memory budget
+	rejectInvalid   = "invalid"   // header not decodable, format not supported
+)
+
+var (
+	metricSourceRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_source_rejected_total",
+		Help: "Number of originals rejected by limits before decoding",
+	}, []string{"bucket", "reason"})
+
+	metricDecodeMemory = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "image_decode_memory_bytes",
+		Help: "Estimated pixel memory of running decodes",
+	})
+)
+
+// sourceHeader of original from DecodeConfig, checked against bucket limits
+type sourceHeader struct {
+	Format string // jpeg png ...
+	Config image.Config
+	Memory int64 // estimated decode bytes, see utilimage.DecodeMemory
+}
+
+func (x *bucketHandler) reject(reason string, err error, format string, args ...any) error {
+
+	metricSourceRejected.WithLabelValues(x.Name, reason).Inc()
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))
+}
+
+// checkSource header only, before full decode allocates pixels;
+// ErrSourceTooLarge or ErrSourceInvalid if rejected
+func (x *bucketHandler) checkSource(data []byte) (*sourceHeader, error) {
+
+	if int64(len(data)) > x.MaxBytes {
+		return nil, x.reject(rejectBytes, ErrSourceTooLarge, "%d bytes, limit %d", len(data), x.MaxBytes)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, x.reject(rejectInvalid, ErrSourceInvalid, "%v", err)
+	}
+	if _, ok := sourceFormats[format]; !ok {
+		return nil, x.reject(rejectInvalid, ErrSourceInvalid, "format %v", format)
+	}
+	if cfg.Width < 1 || cfg.Height < 1 {
+		return nil, x.reject(rejectInvalid, ErrSourceInvalid, "size %vx%v", cfg.Width, cfg.Height)
+	}
+	if cfg.Width > x.MaxWidth || cfg.Height > x.MaxHeight {
+		return nil, x.reject(rejectDimension, ErrSourceTooLarge, "size %vx%v, limit %vx%v", cfg.Width, cfg.Height, x.MaxWidth, x.MaxHeight)
+	}
+	if mp := float64(cfg.Width) * float64(cfg.Height) / 1e6; mp > x.MaxMegapixels {
+		return nil, x.reject(rejectPixels, ErrSourceTooLarge, "%.1f megapixels, limit %v", mp, x.MaxMegapixels)
+	}
+
+	res := &sourceHeader{
+		Format: format,
+		Config: cfg,
+		Memory: utilimage.DecodeMemory(data, cfg, format),
+	}
+
+	if size := x.decodeBudget.Size(); size > 0 && res.Memory > size {
+		return nil, x.reject(rejectMemory, ErrSourceTooLarge, "decode memory %d, limit %d", res.Memory, size)
+	}
+
+	return res, nil
+}
+
+// acquireDecode wait for decode memory of h, release when pixels are freed
+func (x *bucketHandler) acquireDecode(h *sourceHeader) (func(), error) {
+
+	release, err := x.decodeBudget.Acquire(h.Memory)
+	if errors.Is(err, utilpool.ErrOverBudget) {
+		return nil, x.reject(rejectMemory, ErrSourceTooLarge, "decode memory %d", h.Memory)
+	}
+	if err != nil {
+		return nil, err
+	}
+	metricDecodeMemory.Add(float64(h.Memory))
+
+	return func() {
+		metricDecodeMemory.Sub(float64(h.Memory))
+		release()
+	}, nil
+}
+
+// readSource original checked by limits, size before read;
+// release of decode memory must be called after decoding
+func (x *bucketHandler) readSource(sourceFile string) (info *utilstorage.Info, data []byte, release func(), err error) {
+
+	info, err = x.source.Stat(sourceFile) // before read, later change is detected
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if info.Size > x.MaxBytes {
+		return nil, nil, nil, x.reject(rejectBytes, ErrSourceTooLarge, "%d bytes, limit %d", info.Size, x.MaxBytes)
+	}
+
+	data, err = x.source.Get(sourceFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	h, err := x.checkSource(data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	release, err = x.acquireDecode(h)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return info, data, release, nil
+}
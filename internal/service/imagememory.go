@@ -0,0 +1,178 @@
+package service
+
+import (
+	"fmt"
+	"go-image/internal/util/utilcache"
+	"go-image/internal/util/utilstorage"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	memoryCacheCounters = 10000 // distinct variants of admission sketch
+	memoryRevalidate    = 10    // sec, original of a hit is checked after it; watcher, uploads and purges drop at once
+)
+
+var (
+	metricMemoryHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_memory_cache_hits_total",
+		Help: "Number of variants served from memory",
+	}, []string{"bucket"})
+
+	metricMemoryMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_memory_cache_misses_total",
+		Help: "Number of variants not in memory",
+	}, []string{"bucket"})
+
+	metricMemoryEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "image_memory_cache_evictions_total",
+		Help: "Number of variants evicted from memory by more frequent ones",
+	})
+
+	metricMemoryBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "image_memory_cache_bytes",
+		Help: "Size of variants in memory",
+	})
+)
+
+// memoryItem encoded variant in memory, valid while the original is unchanged
+type memoryItem struct {
+	Data    []byte
+	Mime    string
+	ModTime time.Time
+	ETag    string
+
+	Source        string // original path
+	SourceSize    int64
+	SourceModTime time.Time
+	Variant       string // spec Key
+
+	checked atomic.Int64 // unix nano of the last original check
+}
+
+func (x *bucketHandler) memoryKey(id string, variant string, ext string) string {
+	return fmt.Sprintf("%s/%s#%s%s", x.Name, id, variant, ext)
+}
+
+// memoryFresh original of m is unchanged, checked by a stat once per memoryRevalidate
+func (x *bucketHandler) memoryFresh(m *memoryItem, spec *variantSpec) bool {
+
+	if m.Variant != spec.Key {
+		return false
+	}
+
+	now := time.Now()
+	if now.Sub(time.Unix(0, m.checked.Load())) < memoryRevalidate*time.Second {
+		return true
+	}
+
+	info, err := x.source.Stat(m.Source)
+	if err != nil || info.Size != m.SourceSize || !info.ModTime.Equal(m.SourceModTime) {
+		return false
+	}
+	m.checked.Store(now.UnixNano())
+	return true
+}
+
+// memoryGet variant from memory if its original is unchanged, nil if not
+func (x *bucketHandler) memoryGet(id string, spec *variantSpec, ext string) *ImageItem {
+
+	if x.memory == nil {
+		return nil
+	}
+
+	key := x.memoryKey(id, spec.Name, ext)
+	v, ok := x.memory.Get(key)
+	if ok {
+		m := v.(*memoryItem)
+		if x.memoryFresh(m, spec) {
+			metricMemoryHits.WithLabelValues(x.Name).Inc()
+			return &ImageItem{
+				Name:    spec.Name + ext,
+				Data:    m.Data,
+				Mime:    m.Mime,
+				Size:    int64(len(m.Data)),
+				ETag:    m.ETag,
+				ModTime: m.ModTime,
+			}
+		}
+		x.memoryDelete(key) // stale
+	}
+
+	metricMemoryMisses.WithLabelValues(x.Name).Inc()
+	return nil
+}
+
+// memoryPut fresh cache file item of source if admitted (frequently requested)
+func (x *bucketHandler) memoryPut(id string, spec *variantSpec, ext string, item *ImageItem, source *utilstorage.Info) {
+
+	if x.memory == nil || item == nil {
+		return
+	}
+
+	key := x.memoryKey(id, spec.Name, ext)
+	if !x.memory.Admits(key, item.Size) {
+		return
+	}
+
+	data := item.Data
+	if data == nil {
+		var err error
+		data, err = x.cache.Get(x.cacheFile(id, spec.Name, ext))
+		if err != nil || int64(len(data)) != item.Size {
+			return // changed meanwhile
+		}
+	}
+
+	m := &memoryItem{
+		Data:          data,
+		Mime:          item.Mime,
+		ModTime:       item.ModTime,
+		ETag:          item.ETag,
+		Source:        source.Key,
+		SourceSize:    source.Size,
+		SourceModTime: source.ModTime,
+		Variant:       spec.Key,
+	}
+	m.checked.Store(time.Now().UnixNano()) // source is of this request
+	admitted, evicted := x.memory.Set(key, m, int64(len(data)))
+	if admitted {
+		metricMemoryEvictions.Add(float64(evicted))
+		metricMemoryBytes.Set(float64(x.memory.Bytes()))
+	}
+}
+
+func (x *bucketHandler) memoryDelete(key string) {
+
+	if x.memory != nil && x.memory.Delete(key) {
+		metricMemoryBytes.Set(float64(x.memory.Bytes()))
+	}
+}
+
+// memoryPurge variants of id, all of bucket if id is ""
+func (x *bucketHandler) memoryPurge(id string) {
+
+	if x.memory == nil {
+		return
+	}
+
+	prefix := x.Name + "/"
+	if id != "" {
+		prefix += id + "#"
+	}
+	if x.memory.DeletePrefix(prefix) > 0 {
+		metricMemoryBytes.Set(float64(x.memory.Bytes()))
+	}
+}
+
+// newMemoryCache shared by buckets, nil if sizeMB < 1
+func newMemoryCache(sizeMB int) *utilcache.Cache {
+
+	if sizeMB < 1 {
+		return nil
+	}
+	return utilcache.New(int64(sizeMB)<<20, 0, memoryCacheCounters)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go-image/internal/util/utilstorage"
+	"path"
+)
+
+const metaExt = ".meta" // id#N.ext.meta
+
+// cacheMeta state of the source the cache file was created from
+type cacheMeta struct {
+	Source  string `json:"source"` // file name, ext may change
+	ModTime int64  `json:"mtime"`  // unix nano
+	Size    int64  `json:"size"`
+	Hash    string `json:"sha256"`
+	Variant string `json:"variant"` // variantSpec.Key, config change makes cache stale
+}
+
+func newCacheMeta(sourceFile string, info *utilstorage.Info, data []byte) *cacheMeta {
+
+	hash := sha256.Sum256(data)
+
+	return &cacheMeta{
+		Source:  path.Base(sourceFile),
+		ModTime: info.ModTime.UnixNano(),
+		Size:    info.Size,
+		Hash:    hex.EncodeToString(hash[:]),
+	}
+}
+
+func (x *bucketHandler) readCacheMeta(cacheFile string) *cacheMeta {
+
+	data, err := x.cache.Get(cacheFile + metaExt)
+	if err != nil {
+		return nil
+	}
+
+	res := &cacheMeta{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil
+	}
+
+	return res
+}
+
+func (x *bucketHandler) writeCacheMeta(cacheFile string, meta *cacheMeta) error {
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return x.cache.Put(cacheFile+metaExt, data)
+}
+
+func (x *bucketHandler) sourceHash(sourceFile string) (string, error) {
+
+	data, err := x.source.Get(sourceFile)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// metaFresh meta is of source (size and mtime, no hash) and the current variant settings
+func metaFresh(meta *cacheMeta, source *utilstorage.Info, spec *variantSpec) bool {
+
+	return meta != nil && meta.Source == source.Name() && meta.Variant == spec.Key &&
+		meta.Size == source.Size && meta.ModTime == source.ModTime.UnixNano()
+}
+
+// cacheFresh meta of cache file if created from the source of info with the current
+// variant settings, nil if stale; content hash is checked only if mtime changed.
+// The cache file itself is not checked, its readers fall back to create
+func (x *bucketHandler) cacheFresh(cacheFile string, source *utilstorage.Info, spec *variantSpec) *cacheMeta {
+
+	if source == nil {
+		return nil
+	}
+
+	meta := x.readCacheMeta(cacheFile)
+	if meta == nil || meta.Source != source.Name() || meta.Variant != spec.Key || meta.Size != source.Size {
+		return nil // no meta: created by old version
+	}
+
+	if source.ModTime.UnixNano() == meta.ModTime {
+		return meta
+	}
+
+	// touched or copied, same content keeps cache
+	hash, err := x.sourceHash(source.Key)
+	if err != nil || hash != meta.Hash {
+		return nil
+	}
+
+	meta.ModTime = source.ModTime.UnixNano()
+	_ = x.writeCacheMeta(cacheFile, meta) // no re-hash next time
+
+	return meta
+}
+
+// cacheReady cache file exists and is fresh, nothing to create
+func (x *bucketHandler) cacheReady(cacheFile string, source *utilstorage.Info, spec *variantSpec) bool {
+
+	return x.cacheFresh(cacheFile, source, spec) != nil && x.fileSize(cacheFile) > 0
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"go-image/internal/config"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// solidPNG uncompressed, same size of any colour
+func solidPNG(t *testing.T, c color.Color) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	enc := &png.Encoder{CompressionLevel: png.NoCompression}
+	if err := enc.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCacheFresh(t *testing.T) {
+
+	dir := t.TempDir()
+	cfg := config.NewAppConfig()
+	cfg.ImageBuckets = []config.AppConfigImageBucket{{Name: "b", Source: dir + "/src", Cache: dir + "/cache", SizeCount: 2}}
+	srv := MustNewImageSizeService(cfg).(*defaultImageSizeSrv)
+	h := srv.bucketHandlers["b"]
+
+	original := filepath.Join(dir, "src", "a-1", "a-1.png")
+	cacheFile := filepath.Join(dir, "cache", "a-1", "a-1#1.jpg")
+	write := func(data []byte, mtime time.Time) {
+		if err := os.MkdirAll(filepath.Dir(original), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(original, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(original, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func() (*ImageItem, time.Time) {
+		item, err := srv.Image("b", "a-1", "1", ".jpg", "")
+		if err != nil || item == nil {
+			t.Fatalf("image: %v %v", item, err)
+		}
+		info, err := os.Stat(cacheFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return item, info.ModTime()
+	}
+
+	red, blue := solidPNG(t, color.RGBA{255, 0, 0, 255}), solidPNG(t, color.RGBA{0, 0, 255, 255})
+	if len(red) != len(blue) {
+		t.Fatalf("sizes differ: %v %v", len(red), len(blue))
+	}
+
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	write(red, mtime)
+	_, created := get()
+	first := h.readCacheMeta("a-1/a-1#1.jpg")
+
+	// old cache file mtime, a new one is seen by it
+	old := created.Add(-time.Hour)
+	if err := os.Chtimes(cacheFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// touched, same content: re-hashed, kept, meta of new mtime
+	mtime = mtime.Add(time.Hour)
+	write(red, mtime)
+	_, modTime := get()
+	if !modTime.Equal(old) {
+		t.Fatalf("touched original regenerated the variant: %v", modTime)
+	}
+	if meta := h.readCacheMeta("a-1/a-1#1.jpg"); meta == nil || meta.ModTime != mtime.UnixNano() || meta.Hash != first.Hash {
+		t.Fatalf("meta not of touched original: %+v", meta)
+	}
+
+	// replaced, same size: hash differs, regenerated
+	mtime = mtime.Add(time.Hour)
+	write(blue, mtime)
+	item, modTime := get()
+	if modTime.Equal(old) {
+		t.Fatalf("replaced original kept the variant: %v", modTime)
+	}
+	if meta := h.readCacheMeta("a-1/a-1#1.jpg"); meta == nil || meta.Hash == first.Hash {
+		t.Fatalf("meta not of replaced original: %+v", meta)
+	}
+
+	// replaced by another ext: old original removed, regenerated from the new
+	second := item
+	if err := os.Remove(original); err != nil {
+		t.Fatal(err)
+	}
+	original = filepath.Join(dir, "src", "a-1", "a-1.gif")
+	gifData := &bytes.Buffer{}
+	if err := gif.Encode(gifData, image.NewGray(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatal(err)
+	}
+	write(gifData.Bytes(), mtime)
+	item, _ = get()
+	if item.ETag == second.ETag {
+		t.Fatalf("original of other ext kept the variant: %v", item.ETag)
+	}
+	if meta := h.readCacheMeta("a-1/a-1#1.jpg"); meta == nil || meta.Source != "a-1.gif" {
+		t.Fatalf("meta not of new original: %+v", meta)
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+	"go-image/internal/util/utilimage"
+)
+
+const (
+	paletteName = "palette" // cache file id#palette.json
+	paletteSize = 5
+)
+
+// PaletteInfo colours of original, cached as json
+type PaletteInfo struct {
+	Dominant string   `json:"dominant"` // #rrggbb
+	Palette  []string `json:"palette"`  // #rrggbb, most frequent first, up to 5
+}
+
+// palette of id, created on first call, nil if no original
+func (x *bucketHandler) palette(id string) (*PaletteInfo, error) {
+
+	spec := x.paletteSpec
+
+	create := func(data []byte) (any, error) {
+		colors, err := utilimage.PaletteOf(data, &spec.Transform, paletteSize)
+		if err != nil {
+			return nil, err
+		}
+
+		res := &PaletteInfo{Palette: []string{}}
+		for _, c := range colors {
+			res.Palette = append(res.Palette, fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B))
+		}
+		if len(res.Palette) > 0 {
+			res.Dominant = res.Palette[0]
+		}
+		return res, nil
+	}
+
+	res := &PaletteInfo{}
+	found, err := x.derived(id, spec, create, res)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (x *defaultImageSizeSrv) Palette(bucket string, id string) (*PaletteInfo, error) {
+
+	h, err := x.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.palette(id)
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"encoding/base64"
+	"go-image/internal/util/utilimage"
+	"go-image/internal/util/utilstorage"
+)
+
+const (
+	placeholderName        = "placeholder" // cache file id#placeholder.json
+	placeholderQuality     = 60
+	defaultPlaceholderSize = 20 // px
+)
+
+// PlaceholderInfo low quality placeholders of original, cached as json
+type PlaceholderInfo struct {
+	BlurHash  string `json:"blurhash"`
+	ThumbHash string `json:"thumbhash"` // base64
+	DataURI   string `json:"data_uri"`  // tiny blurred image
+	Width     int    `json:"width"`     // of DataURI image
+	Height    int    `json:"height"`
+}
+
+// placeholder of id, created on first call, nil if no original
+func (x *bucketHandler) placeholder(id string) (*PlaceholderInfo, error) {
+
+	spec := x.placeholderSpec
+
+	create := func(data []byte) (any, error) {
+		format := imageFormats[spec.Ext]
+		enc := &utilimage.EncodeOptions{
+			Format:     format.Format,
+			Quality:    spec.Quality,
+			Background: x.Background,
+		}
+
+		p, err := utilimage.Placeholder(data, &spec.Transform, enc)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PlaceholderInfo{
+			BlurHash:  p.BlurHash,
+			ThumbHash: base64.StdEncoding.EncodeToString(p.ThumbHash),
+			DataURI:   utilimage.DataURI(format.Mime, p.Image),
+			Width:     p.Width,
+			Height:    p.Height,
+		}, nil
+	}
+
+	res := &PlaceholderInfo{}
+	found, err := x.derived(id, spec, create, res)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// placeholderCached of id if created already from source, nil if not
+func (x *bucketHandler) placeholderCached(id string, source *utilstorage.Info) *PlaceholderInfo {
+
+	res := &PlaceholderInfo{}
+	if !x.derivedCached(id, source, x.placeholderSpec, res) {
+		return nil
+	}
+
+	return res
+}
+
+func (x *defaultImageSizeSrv) Placeholder(bucket string, id string) (*PlaceholderInfo, error) {
+
+	h, err := x.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.placeholder(id)
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"fmt"
+	"go-image/internal/config/consts"
+	"go-image/internal/util/utilhttp"
+	xlog "go-image/internal/util/utillog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const purgeWebhookTimeout = 10 // sec, of one webhook request
+
+// purgeWebhookClient http.DefaultTransport, tuned by mustConfigRuntime
+var purgeWebhookClient = &http.Client{Timeout: purgeWebhookTimeout * time.Second}
+
+// purge reasons, metric label and webhook field
+const (
+	purgeReasonAPI    = "api"    // purge cache api
+	purgeReasonDelete = "delete" // original deleted by api
+	purgeReasonUpload = "upload" // original replaced by api
+	purgeReasonWatch  = "watch"  // original changed on disk
+)
+
+var (
+	metricPurge = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_purge_total",
+		Help: "Number of cache purges",
+	}, []string{"bucket", "reason"})
+
+	metricPurgedFiles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_purged_files_total",
+		Help: "Number of removed cached variants",
+	}, []string{"bucket"})
+)
+
+// purgeEvent webhook body, CDN invalidation
+type purgeEvent struct {
+	Bucket string   `json:"bucket"`
+	ID     string   `json:"id,omitempty"` // empty if whole bucket
+	Reason string   `json:"reason"`
+	Count  int      `json:"count"` // removed variants, not derived data (placeholder, palette)
+	Paths  []string `json:"paths"` // url paths, "*" is wildcard
+}
+
+// notifyPurge metrics and webhook (async)
+func (x *bucketHandler) notifyPurge(id string, reason string, count int) {
+
+	metricPurge.WithLabelValues(x.Name, reason).Inc()
+	metricPurgedFiles.WithLabelValues(x.Name).Add(float64(count))
+
+	if x.PurgeWebhook == "" {
+		return
+	}
+
+	path := strings.NewReplacer(":bucket", x.Name, ":id", id, ":name", "*").Replace(consts.PathImageSizeAPI)
+	if id == "" {
+		path = strings.NewReplacer(":bucket", x.Name, ":id/:name", "*").Replace(consts.PathImageSizeAPI)
+	}
+
+	event := &purgeEvent{
+		Bucket: x.Name,
+		ID:     id,
+		Reason: reason,
+		Count:  count,
+		Paths:  []string{path},
+	}
+
+	go func() {
+		_, err := utilhttp.PostJSONWith(purgeWebhookClient, x.PurgeWebhook, nil, nil, event)
+		if err != nil {
+			xlog.Error("bucket %v purge webhook: %v", x.Name, err)
+		}
+	}()
+}
+
+// purgeBucket remove all cached variants of bucket
+func (x *bucketHandler) purgeBucket() (count int, err error) {
+
+	defer x.memoryPurge("") // after files, also on error
+
+	files, err := x.cache.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, f := range files {
+		if err := x.cache.Delete(f.Key); err != nil {
+			return count, err
+		}
+		if _, _, _, ok := x.parseCacheName(f.Name()); ok {
+			count++ // variant, not meta or derived
+		}
+	}
+
+	return count, nil
+}
+
+// deleteSource remove original (any ext, stored only: not of http origin) and cached variants
+func (x *bucketHandler) deleteSource(id string) (found bool, count int, err error) {
+
+	files, err := x.source.List(x.subDir(id) + "/" + id + ".")
+	if err != nil {
+		return false, 0, err
+	}
+
+	for _, f := range files {
+		if ext := path.Ext(f.Key); !slices.Contains(sourceExts, ext) || f.Name() != id+ext {
+			continue
+		}
+		found = true
+		if err := x.source.Delete(f.Key); err != nil {
+			return found, 0, err
+		}
+	}
+
+	count, err = x.purgeCache(id)
+	if err != nil {
+		return found, count, err
+	}
+
+	return found, count, nil
+}
+
+func (x *defaultImageSizeSrv) bucket(bucket string) (*bucketHandler, error) {
+
+	h := x.bucketHandlers[bucket]
+	if h == nil {
+		return nil, fmt.Errorf("error no bucket: %s", bucket)
+	}
+
+	return h, nil
+}
+
+func (x *defaultImageSizeSrv) DeleteSource(bucket string, id string) (found bool, count int, err error) {
+
+	h, err := x.bucket(bucket)
+	if err != nil {
+		return false, 0, err
+	}
+
+	found, count, err = h.deleteSource(id)
+	if err != nil {
+		return found, count, err
+	}
+	if found || count > 0 {
+		h.notifyPurge(id, purgeReasonDelete, count)
+	}
+
+	return found, count, nil
+}
+
+func (x *defaultImageSizeSrv) PurgeCache(bucket string, id string) (count int, err error) {
+
+	h, err := x.bucket(bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err = h.purgeCache(id)
+	if err != nil {
+		return count, err
+	}
+	h.notifyPurge(id, purgeReasonAPI, count)
+
+	return count, nil
+}
+
+func (x *defaultImageSizeSrv) PurgeBucket(bucket string) (count int, err error) {
+
+	h, err := x.bucket(bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err = h.purgeBucket()
+	if err != nil {
+		return count, err
+	}
+	h.notifyPurge("", purgeReasonAPI, count)
+
+	return count, nil
+}
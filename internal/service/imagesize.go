@@ -1,16 +1,27 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"go-image/internal/config"
+	"go-image/internal/util/utilcache"
 	"go-image/internal/util/utilfile"
+	"go-image/internal/util/utilhttp"
 	"go-image/internal/util/utilimage"
 	xlog "go-image/internal/util/utillog"
+	"go-image/internal/util/utilpool"
+	"go-image/internal/util/utilstorage"
 	"go-image/internal/util/utilstring"
-	"os"
+	"image/color"
+	"io"
+	"path"
 	"path/filepath"
 	"strings"
-	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -18,41 +29,120 @@ const (
 	defaultImageSizeCount = 6   // ImageSizeVariants
 	defaultImageSizeStep  = 200 // px ImageSizeDelta
 	defaultWatermarkAfter = 400
+	defaultDynamicMax     = 2000 // px
+
+	defaultAnimationMaxFrames = 300
+	defaultAnimationMaxPixels = 100_000_000 // frames x width x height
 )
 
-type locker struct {
-	mu sync.Mutex
-}
+// ICC profile policies of originals
+const (
+	iccConvert = "convert" // to sRGB
+	iccIgnore  = "ignore"  // pixels as is
+)
 
-func (x *locker) lock() {
-	x.mu.Lock()
+// sourceExts originals, lookup order
+var sourceExts = []string{".jpg", ".jpeg", ".png", ".webp", ".gif", ".bmp", ".tif", ".tiff"}
 
+type imageFormat struct {
+	Format string // utilimage format
+	Mime   string
 }
-func (x *locker) unlock() {
-	x.mu.Unlock()
+
+// imageFormats output formats by ext
+var imageFormats = map[string]imageFormat{
+	".jpg":  {Format: utilimage.FormatJPEG, Mime: "image/jpeg"},
+	".webp": {Format: utilimage.FormatWEBP, Mime: "image/webp"},
+	".png":  {Format: utilimage.FormatPNG, Mime: "image/png"},
+	".gif":  {Format: utilimage.FormatGIF, Mime: "image/gif"},
 }
 
+// variantExts imageFormats in listing order
+var variantExts = []string{".jpg", ".webp", ".png", ".gif"}
+
+// ErrBusy all image workers busy and queue full, retry later
+var ErrBusy = utilpool.ErrBusy
+
 type ImageItem struct {
 	Name string // 1.jpg
-	File string
+	File string // local cache file, else Data
 	Data []byte
 	Mime string
 	Size int64
+	Vary string // Accept if format negotiated
+
+	ETag    string    // strong, quoted
+	ModTime time.Time // Last-Modified
 }
 
 type ImageSizeService interface {
-	Image(bucket string, id string, sizeVariant int, ext string) (img *ImageItem, err error)
+	// Image accept is Accept header, used for .jpg to .webp negotiation
+	// variant is size number "2", preset name "thumb" or dynamic "300x200-cover-north"
+	Image(bucket string, id string, variant string, ext string, accept string) (img *ImageItem, err error)
+	// PutSource verify and store original, replaces existing
+	PutSource(bucket string, id string, r io.Reader) (*SourceItem, error)
+	// HasBucket bucket exists
+	HasBucket(bucket string) bool
+	// DeleteSource remove original and its variants, found false if no original
+	DeleteSource(bucket string, id string) (found bool, count int, err error)
+	// PurgeCache remove variants of id
+	PurgeCache(bucket string, id string) (count int, err error)
+	// PurgeBucket remove all variants of bucket
+	PurgeBucket(bucket string) (count int, err error)
+	// Info original metadata, placeholder and variants, nil if not exists,
+	// debug decodes the original for variant crop rects
+	Info(bucket string, id string, debug bool) (*SourceInfo, error)
+	// Placeholder BlurHash, ThumbHash and tiny data uri of original, nil if not exists
+	Placeholder(bucket string, id string) (*PlaceholderInfo, error)
+	// Palette dominant colour and palette of original, nil if not exists
+	Palette(bucket string, id string) (*PaletteInfo, error)
 }
 type bucketHandler struct {
-	Name           string
-	Source         string
-	Cache          string
-	SizeCount      int
-	SizeStep       int
-	Watermark      string
-	Quality        int
-	hlSync         *locker
-	WatermarkAfter int
+	Name              string
+	Source            string
+	Cache             string
+	source            utilstorage.Storage // originals
+	cache             utilstorage.Storage // variants, meta and derived
+	SizeCount         int
+	SizeStep          int
+	Watermark         string
+	Quality           int
+	flight            *singleflight.Group // dedup same cache file, shared
+	pool              *utilpool.Pool      // decode/encode jobs, shared
+	WatermarkAfter    int
+	AutoWebP          bool
+	Background        color.RGBA // fill of transparent sources for .jpg .webp
+	ValidateCache     bool
+	WatchSource       bool
+	MaxBytes          int64            // of originals
+	MaxDimension      int              // upload, px
+	MaxWidth          int              // of originals, px
+	MaxHeight         int              // of originals, px
+	MaxMegapixels     float64          // of originals
+	decodeBudget      *utilpool.Budget // estimated pixel memory, shared
+	memory            *utilcache.Cache // hot encoded variants, shared, nil if off
+	CacheMaxBytes     int64
+	CacheMaxAge       int          // sec, of last access
+	JanitorInterval   int          // sec
+	access            *cacheAccess // nil if no janitor
+	PurgeWebhook      string
+	DynamicSize       bool // WxH variants from url
+	DynamicMax        int  // px, limit of WxH
+	Resample          string
+	ScaledDecode      bool
+	Metadata          string // EXIF/XMP policy of variants
+	ICC               string // iccConvert iccIgnore
+	EmbedSRGB         bool
+	animation         utilimage.AnimationOptions // limits of animated gif originals
+	AnimatedWebP      bool
+	watermark         *utilimage.WatermarkOptions // nil if none
+	watermarkKey      string                      // of variant Key
+	PlaceholderSize   int                         // px
+	PlaceholderFormat string                      // ext
+	placeholderSpec   *variantSpec
+	paletteSpec       *variantSpec
+	presets           map[string]*variantSpec
+	presetList        []*variantSpec // config order
 }
 
 func (x *bucketHandler) subDir(id string) string {
@@ -65,101 +155,209 @@ func (x *bucketHandler) subDir(id string) string {
 	return strings.Join(parts, "-")
 
 }
-func (x *bucketHandler) sourceFile(id string, ext string) string {
 
-	sub := x.subDir(id)
-	res := filepath.Join(x.Source, sub, fmt.Sprintf("%s%s", id, ext))
-	return res
+// findSource stat of original with any of sourceExts, nil if not exists;
+// err is of storage (origin down, ...), never of a missing original
+func (x *bucketHandler) findSource(id string) (*utilstorage.Info, error) {
+
+	info, err := x.source.Find(path.Join(x.subDir(id), id), sourceExts)
+	if errors.Is(err, utilstorage.ErrNotExist) {
+		return nil, nil
+	}
+	return info, err
 }
 
-func (x *bucketHandler) cacheFile(id string, sizeVariant int, ext string) string {
+// sourceFile storage key of original, "" if not exists
+func (x *bucketHandler) sourceFile(id string) (string, error) {
+
+	info, err := x.findSource(id)
+	if info == nil {
+		return "", err
+	}
+	return info.Key, nil
+}
+
+// cacheFile storage key of variant
+func (x *bucketHandler) cacheFile(id string, variant string, ext string) string {
 
 	sub := x.subDir(id)
-	res := filepath.Join(x.Cache, sub, fmt.Sprintf("%s#%d%s", id, sizeVariant, ext))
+	res := path.Join(sub, fmt.Sprintf("%s#%s%s", id, variant, ext))
 	return res
 }
 
-func (x *bucketHandler) fileSize(path string) int64 {
+func (x *bucketHandler) fileSize(cacheFile string) int64 {
 
-	return utilfile.FileSize(path)
+	info, err := x.cache.Stat(cacheFile)
+	if err != nil {
+		return 0
+	}
+	return info.Size
 
 }
 
-func (x *bucketHandler) imageInSourceExists(id string, ext string) bool {
+func (x *bucketHandler) imageInSourceExists(id string) bool {
 	//
-	sourceFile := x.sourceFile(id, ext)
-	return utilfile.FileExists(sourceFile)
+	sourceFile, err := x.sourceFile(id)
+	return err == nil && sourceFile != ""
 	//
 }
-func (x *bucketHandler) imageInCacheExists(id string, sizeVariant int, ext string) bool {
-	//
-	cacheFile := x.cacheFile(id, sizeVariant, ext)
-	return utilfile.FileExists(cacheFile)
-	//
+
+// etag of cache file content version: source hash, name, size, mtime
+func (x *bucketHandler) etag(sourceHash string, info *utilstorage.Info) string {
+
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d", sourceHash, info.Name(), info.Size, info.ModTime.UnixNano())))
+
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// purgeCache remove all variants of id (files "id#*")
+func (x *bucketHandler) purgeCache(id string) (count int, err error) {
+
+	defer x.memoryPurge(id) // after files, also on error
+
+	files, err := x.cache.List(x.subDir(id) + "/" + id + "#")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, f := range files {
+		if err := x.cache.Delete(f.Key); err != nil {
+			return count, err
+		}
+		if _, _, _, ok := x.parseCacheName(f.Name()); ok {
+			count++ // variant, not meta or derived
+		}
+	}
+
+	return count, nil
+}
+
+// removeVariant remove cache file and its meta, if any
+func (x *bucketHandler) removeVariant(id string, variant string, ext string) {
+
+	x.memoryDelete(x.memoryKey(id, variant, ext))
+
+	cacheFile := x.cacheFile(id, variant, ext)
+	if _, err := x.cache.Stat(cacheFile); errors.Is(err, utilstorage.ErrNotExist) {
+		return // usual of unknown ids, no deletes
+	}
+	for _, f := range []string{cacheFile, cacheFile + metaExt} {
+		if err := x.cache.Delete(f); err != nil {
+			xlog.Error("bucket %v remove %v: %v", x.Name, f, err)
+		}
+	}
 }
 
-func (x *bucketHandler) readImageFromCache(id string, sizeVariant int, ext string) *ImageItem {
+// readImageFromCache of meta read by cacheFresh, nil if not exists
+func (x *bucketHandler) readImageFromCache(id string, variant string, ext string, meta *cacheMeta) *ImageItem {
 
-	cacheFile := x.cacheFile(id, sizeVariant, ext)
-	fileSize := x.fileSize(cacheFile)
+	if meta == nil {
+		return nil
+	}
+
+	cacheFile := x.cacheFile(id, variant, ext)
+	info, err := x.cache.Stat(cacheFile)
 
 	// if exists
-	if fileSize > 0 {
+	if err == nil && info.Size > 0 {
 		res := &ImageItem{}
 		res.Data = nil
-		res.File = cacheFile
-		res.Size = fileSize
-		res.Mime = "image/jpeg"
-		res.Name = fmt.Sprintf("%d.jpg", sizeVariant)
+		res.File = utilstorage.LocalPath(x.cache, cacheFile)
+		if res.File == "" {
+			// remote, read now
+			res.Data, err = x.cache.Get(cacheFile)
+			if err != nil || int64(len(res.Data)) != info.Size {
+				return nil // changed meanwhile
+			}
+		}
+		res.Size = info.Size
+		res.Mime = imageFormats[ext].Mime
+		res.Name = fmt.Sprintf("%s%s", variant, ext)
+		res.ModTime = info.ModTime
+		res.ETag = x.etag(meta.Hash, info)
 		return res
 	}
 
 	return nil
 }
-func (x *bucketHandler) writeImageToCache(id string, sizeVariant int, ext string) (err error) {
+func (x *bucketHandler) writeImageToCache(id string, spec *variantSpec, ext string) (err error) {
 
-	// sync writing and hdd load
-	// may be multi-task
+	// one job per cache file, concurrent requests wait for it
+	key := fmt.Sprintf("%s/%s#%s%s", x.Name, id, spec.Name, ext)
 
-	x.hlSync.lock()
-	defer x.hlSync.unlock()
+	_, err, _ = x.flight.Do(key, func() (any, error) {
+		// re-check, may be created by previous flight
+		source, err := x.findSource(id)
+		if err != nil {
+			return nil, err
+		}
+		if x.cacheReady(x.cacheFile(id, spec.Name, ext), source, spec) {
+			return nil, nil
+		}
 
-	//
-	// re-check after lock acquired
-	if x.imageInCacheExists(id, sizeVariant, ext) {
-		return nil
-	}
+		return nil, x.pool.Do(func() error {
+			return x.createImage(id, spec, ext)
+		})
+	})
+
+	return err
+}
 
-	cacheFile := x.cacheFile(id, sizeVariant, ext)
+func (x *bucketHandler) createImage(id string, spec *variantSpec, ext string) (err error) {
 
-	sourceFile := x.sourceFile(id, ext)
+	cacheFile := x.cacheFile(id, spec.Name, ext)
 
-	sourceFile = filepath.Clean(sourceFile)
-	data, err := os.ReadFile(sourceFile)
+	sourceFile, err := x.sourceFile(id)
 	if err != nil {
 		return err
 	}
+	if sourceFile == "" {
+		return fmt.Errorf("error image source not exists: %v", id)
+	}
 
-	width := sizeVariant * x.SizeStep
-	//
-	data, err = utilimage.Resize(data, width, x.Quality)
+	info, data, release, err := x.readSource(sourceFile)
 	if err != nil {
 		return err
 	}
+	defer release()
+	meta := newCacheMeta(sourceFile, info, data)
+	meta.Variant = spec.Key
+
+	enc := &utilimage.EncodeOptions{
+		Format:     imageFormats[ext].Format,
+		Quality:    spec.Quality,
+		Background: x.Background,
+		Metadata:   x.Metadata,
+		EmbedSRGB:  x.EmbedSRGB,
+	}
+	//
+	animated := false
+	if ext == ".gif" || ext == ".webp" && x.AnimatedWebP {
+		var res []byte
+		res, animated, err = utilimage.TransformAnimated(data, &spec.Transform, enc, x.frameOps(spec), &x.animation)
+		if err != nil {
+			return err
+		}
+		if animated {
+			data = res
+		}
+	}
 
-	if width > x.WatermarkAfter {
-		data, err = utilimage.Watermark(data, x.Watermark, x.Quality)
+	if !animated {
+		// decoded and encoded once
+		data, err = x.pipeline(data, spec).Run(data, enc)
 		if err != nil {
 			return err
 		}
 	}
 
 	//
-	err = utilfile.MakeAllDirs(filepath.Dir(cacheFile))
+	err = x.cache.Put(cacheFile, data)
 	if err != nil {
 		return err
 	}
-	err = utilfile.FileWrite(cacheFile, data)
+	// meta last, crash before it means stale
+	err = x.writeCacheMeta(cacheFile, meta)
 	if err != nil {
 		return err
 	}
@@ -167,15 +365,118 @@ func (x *bucketHandler) writeImageToCache(id string, sizeVariant int, ext string
 	return nil
 }
 
-func (x *bucketHandler) image(id string, sizeVariant int, ext string) (img *ImageItem, err error) {
+// parseCacheName "id#variant.ext" => id, variant, ext
+func (x *bucketHandler) parseCacheName(name string) (id string, variant string, ext string, ok bool) {
+
+	ext = filepath.Ext(name)
+	if _, ok := imageFormats[ext]; !ok {
+		return "", "", "", false
+	}
+
+	id, variant, found := strings.Cut(strings.TrimSuffix(name, ext), "#")
+	if !found || !utilstring.IsValidID(id) || variant == "" {
+		return "", "", "", false
+	}
+
+	return id, variant, ext, true
+}
+
+// removeTempFiles orphaned by crash during write of fs cache, call before serving
+func (x *bucketHandler) removeTempFiles() {
+
+	dir, ok := x.cache.Local()
+	if !ok {
+		return // s3 put is atomic
+	}
 
-	if sizeVariant < 1 || sizeVariant > x.SizeCount {
+	count, err := utilfile.RemoveTempFiles(dir)
+	if err != nil {
+		xlog.Error("bucket %v remove temp files: %v", x.Name, err)
+	}
+	if count > 0 {
+		xlog.Warn("bucket %v removed temp files: %v", x.Name, count)
+	}
+}
+
+// validateCache decode every cache file, remove and regenerate corrupted
+func (x *bucketHandler) validateCache() {
+
+	var checked, corrupted int
+
+	err := func() error {
+		files, err := x.cache.List("")
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			id, variant, ext, ok := x.parseCacheName(f.Name())
+			if !ok {
+				continue
+			}
+
+			checked++
+
+			data, err := x.cache.Get(f.Key)
+			if errors.Is(err, utilstorage.ErrNotExist) {
+				continue // removed meanwhile
+			}
+			if err != nil {
+				return err
+			}
+			if err := utilimage.Validate(data); err == nil {
+				continue
+			}
+
+			corrupted++
+			xlog.Warn("bucket %v corrupted cache file: %v", x.Name, f.Key)
+
+			if err := x.cache.Delete(f.Key); err != nil {
+				return err
+			}
+
+			// regenerate now, or on next request
+			if spec := x.variant(variant); spec != nil && x.imageInSourceExists(id) {
+				if err := x.writeImageToCache(id, spec, ext); err != nil {
+					xlog.Error("bucket %v regenerate %v: %v", x.Name, f.Key, err)
+				}
+			}
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		xlog.Error("bucket %v validate cache: %v", x.Name, err)
+	}
+
+	xlog.Info("bucket %v validate cache checked: %v corrupted: %v", x.Name, checked, corrupted)
+}
+
+func (x *bucketHandler) image(id string, variant string, ext string, accept string) (img *ImageItem, err error) {
+
+	spec := x.variant(variant)
+	if spec == nil {
 		return nil, nil
 	}
 	{
-		if ext != ".jpg" {
+		if _, ok := imageFormats[ext]; !ok {
 			return nil, fmt.Errorf("error ext not valid")
 		}
+		if spec.Ext != "" && spec.Ext != ext {
+			return nil, nil // preset has fixed format
+		}
+	}
+
+	vary := ""
+	{
+		// negotiate .jpg => .webp, same url
+		if ext == ".jpg" && x.AutoWebP && spec.Ext == "" {
+			vary = "Accept"
+			if utilhttp.Accepts(accept, imageFormats[".webp"].Mime) {
+				ext = ".webp"
+			}
+		}
 	}
 	{
 		if !utilstring.IsValidID(id) {
@@ -185,23 +486,42 @@ func (x *bucketHandler) image(id string, sizeVariant int, ext string) (img *Imag
 	}
 
 	{
-		// read
-		res := x.readImageFromCache(id, sizeVariant, ext)
-		if res != nil {
+		// hot variant, no cache file read
+		if res := x.memoryGet(id, spec, ext); res != nil {
+			x.touch(x.cacheFile(id, spec.Name, ext))
+			res.Vary = vary
 			return res, nil
 		}
 	}
 
+	source, err := x.findSource(id)
+	if err != nil {
+		return nil, err // storage error, cache is kept
+	}
 	{
 		// continue if image exists
-		if !x.imageInSourceExists(id, ext) {
+		if source == nil {
+			x.removeVariant(id, spec.Name, ext) // source deleted
 			return nil, nil
 		}
 	}
 
+	{
+		// read if created from current source
+		if meta := x.cacheFresh(x.cacheFile(id, spec.Name, ext), source, spec); meta != nil {
+			res := x.readImageFromCache(id, spec.Name, ext, meta)
+			if res != nil {
+				x.memoryPut(id, spec, ext, res, source)
+				x.touch(x.cacheFile(id, spec.Name, ext))
+				res.Vary = vary
+				return res, nil
+			}
+		}
+	}
+
 	{
 		// create
-		err = x.writeImageToCache(id, sizeVariant, ext)
+		err = x.writeImageToCache(id, spec, ext)
 		if err != nil {
 			return nil, err
 		}
@@ -209,8 +529,11 @@ func (x *bucketHandler) image(id string, sizeVariant int, ext string) (img *Imag
 
 	{
 		// read
-		res := x.readImageFromCache(id, sizeVariant, ext)
+		res := x.readImageFromCache(id, spec.Name, ext, x.readCacheMeta(x.cacheFile(id, spec.Name, ext)))
 		if res != nil {
+			x.memoryPut(id, spec, ext, res, source)
+			x.touch(x.cacheFile(id, spec.Name, ext))
+			res.Vary = vary
 			return res, nil
 		}
 	}
@@ -225,34 +548,67 @@ type defaultImageSizeSrv struct {
 	bucketHandlers map[string]*bucketHandler
 }
 
-func (x *defaultImageSizeSrv) Image(bucket string, id string, sizeVariant int, ext string) (img *ImageItem, err error) {
+func (x *defaultImageSizeSrv) Image(bucket string, id string, variant string, ext string, accept string) (img *ImageItem, err error) {
 
 	h := x.bucketHandlers[bucket]
 	if h == nil {
 		return nil, fmt.Errorf("error no bucket: %s", bucket)
 	}
 
-	return h.image(id, sizeVariant, ext)
+	return h.image(id, variant, ext, accept)
 
 }
 
+func (x *defaultImageSizeSrv) HasBucket(bucket string) bool {
+	return x.bucketHandlers[bucket] != nil
+}
+
 func MustNewImageSizeService(appConfig *config.AppConfig) ImageSizeService {
 	imageBuckets := map[string]*bucketHandler{}
 
-	hlSync := &locker{}
+	flight := &singleflight.Group{}
+	pool := utilpool.NewPool(appConfig.Image.MaxJobs, appConfig.Image.QueueDepth)
+	decodeBudget := utilpool.NewBudget(int64(appConfig.Image.DecodeMemoryMB) << 20)
+	memory := newMemoryCache(appConfig.Image.MemoryCacheMB)
 	//
 	for _, v := range appConfig.ImageBuckets {
 		h := &bucketHandler{
-			Name:           v.Name,
-			Source:         v.Source,
-			Cache:          v.Cache,
-			SizeCount:      v.SizeCount,
-			SizeStep:       v.SizeStep,
-			Watermark:      v.Watermark,
-			Quality:        v.Quality,
-			WatermarkAfter: v.WatermarkAfter,
+			Name:              v.Name,
+			Source:            v.Source,
+			Cache:             v.Cache,
+			SizeCount:         v.SizeCount,
+			SizeStep:          v.SizeStep,
+			Watermark:         v.Watermark,
+			Quality:           v.Quality,
+			WatermarkAfter:    v.WatermarkAfter,
+			AutoWebP:          v.AutoWebP,
+			ValidateCache:     v.ValidateCache,
+			WatchSource:       v.WatchSource,
+			MaxBytes:          v.MaxBytes,
+			MaxDimension:      v.MaxDimension,
+			MaxWidth:          v.MaxWidth,
+			MaxHeight:         v.MaxHeight,
+			MaxMegapixels:     v.MaxMegapixels,
+			CacheMaxBytes:     v.CacheMaxBytes,
+			CacheMaxAge:       v.CacheMaxAge,
+			JanitorInterval:   v.CacheJanitorInterval,
+			PurgeWebhook:      v.PurgeWebhook,
+			DynamicSize:       v.DynamicSize,
+			DynamicMax:        v.DynamicMax,
+			Resample:          v.Resample,
+			ScaledDecode:      v.ScaledDecode,
+			Metadata:          v.Metadata,
+			ICC:               v.ICC,
+			EmbedSRGB:         v.EmbedSRGB,
+			AnimatedWebP:      v.AnimatedWebP,
+			PlaceholderSize:   v.PlaceholderSize,
+			PlaceholderFormat: v.PlaceholderFormat,
 			//
-			hlSync: hlSync, // share
+			flight: flight, // share
+			pool:   pool,   // share
+
+			decodeBudget: decodeBudget, // share
+			memory:       memory,       // share
 		}
 
 		if h.Quality < 1 {
@@ -267,24 +623,130 @@ func MustNewImageSizeService(appConfig *config.AppConfig) ImageSizeService {
 			h.SizeStep = defaultImageSizeStep
 		}
 
-		if !utilfile.DirExists(h.Source) {
-			xlog.Warn("image source dir no exists %s", h.Source)
-			err := utilfile.MakeAllDirs(h.Source)
+		if h.MaxBytes < 1 {
+			h.MaxBytes = defaultSourceMaxBytes
+		}
+
+		if h.MaxDimension < 1 {
+			h.MaxDimension = defaultSourceMaxDimension
+		}
+
+		if h.MaxWidth < 1 {
+			h.MaxWidth = h.MaxDimension
+		}
+		if h.MaxHeight < 1 {
+			h.MaxHeight = h.MaxDimension
+		}
+		if h.MaxMegapixels <= 0 {
+			h.MaxMegapixels = defaultSourceMaxMegapixels
+		}
+
+		if h.DynamicMax < 1 {
+			h.DynamicMax = defaultDynamicMax
+		}
+		h.DynamicMax = min(h.DynamicMax, h.MaxDimension)
+
+		if h.Metadata == "" {
+			h.Metadata = utilimage.MetadataStrip
+		}
+		if !utilimage.IsMetadata(h.Metadata) {
+			xlog.Panic("bucket %v metadata not valid: %v", h.Name, h.Metadata)
+		}
+
+		if h.ICC == "" {
+			h.ICC = iccConvert
+		}
+		if h.ICC != iccConvert && h.ICC != iccIgnore {
+			xlog.Panic("bucket %v icc not valid: %v", h.Name, h.ICC)
+		}
+
+		h.animation = utilimage.AnimationOptions{MaxFrames: v.AnimationMaxFrames, MaxPixels: v.AnimationMaxPixels}
+		if h.animation.MaxFrames < 1 {
+			h.animation.MaxFrames = defaultAnimationMaxFrames
+		}
+		if h.animation.MaxPixels < 1 {
+			h.animation.MaxPixels = defaultAnimationMaxPixels
+		}
+
+		if !utilimage.IsResample(h.Resample) {
+			xlog.Panic("bucket %v resample not valid: %v", h.Name, h.Resample)
+		}
+
+		h.Background = utilimage.DefaultBackground
+		if v.Background != "" {
+			c, err := utilimage.ParseColor(v.Background)
 			if err != nil {
-				xlog.Panic("create bucket %v source:  %v", h.Name, err)
+				xlog.Panic("bucket %v background:  %v", h.Name, err)
 			}
+			h.Background = c
 		}
 
-		if !utilfile.DirExists(h.Cache) {
-			xlog.Warn("image cache dir no exists %s", h.Cache)
-			err := utilfile.MakeAllDirs(h.Cache)
-			if err != nil {
-				xlog.Panic("create bucket %v cache:  %v", h.Name, err)
+		if err := h.setWatermark(v.Watermark, v.WatermarkStyle); err != nil {
+			xlog.Panic("bucket %v watermark:  %v", h.Name, err)
+		}
+
+		if h.PlaceholderSize < 1 {
+			h.PlaceholderSize = defaultPlaceholderSize
+		}
+		if h.PlaceholderFormat == "" {
+			h.PlaceholderFormat = "jpg"
+		}
+		if _, ok := imageFormats["."+h.PlaceholderFormat]; !ok {
+			xlog.Panic("bucket %v placeholder_format not valid: %v", h.Name, h.PlaceholderFormat)
+		}
+		h.placeholderSpec = h.newVariantSpec(placeholderName,
+			utilimage.TransformOptions{Width: h.PlaceholderSize, Height: h.PlaceholderSize, Fit: utilimage.FitContain},
+			placeholderQuality, "."+h.PlaceholderFormat, false)
+		h.paletteSpec = h.newVariantSpec(paletteName,
+			utilimage.TransformOptions{Fit: utilimage.FitContain}, 0, "", false)
+
+		h.presets = map[string]*variantSpec{}
+		for _, p := range v.Presets {
+			if err := h.addPreset(p); err != nil {
+				xlog.Panic("bucket %v presets:  %v", h.Name, err)
 			}
 		}
 
+		source, err := newStorage(v.SourceStorage, h.Source, h.MaxBytes)
+		if err != nil {
+			xlog.Panic("create bucket %v source:  %v", h.Name, err)
+		}
+		h.source = source
+
+		if v.CacheStorage.Type == storageHTTP {
+			xlog.Panic("bucket %v cache storage not valid: %v", h.Name, storageHTTP)
+		}
+		cache, err := newStorage(v.CacheStorage, h.Cache, h.MaxBytes)
+		if err != nil {
+			xlog.Panic("create bucket %v cache:  %v", h.Name, err)
+		}
+		h.cache = cache
+
 		xlog.Info("image bucket: %v", *h)
 
+		h.removeTempFiles()
+
+		if h.ValidateCache {
+			go h.validateCache()
+		}
+
+		if h.CacheMaxBytes > 0 || h.CacheMaxAge > 0 {
+			if h.JanitorInterval < 1 {
+				h.JanitorInterval = defaultCacheJanitorInterval
+			}
+			h.access = &cacheAccess{times: map[string]time.Time{}}
+			go h.cacheJanitor()
+		}
+
+		if h.WatchSource {
+			if _, ok := h.source.Local(); !ok {
+				xlog.Panic("bucket %v watch_source needs fs source storage", h.Name)
+			}
+			if err := h.watchSource(); err != nil {
+				xlog.Error("bucket %v watch source: %v", h.Name, err)
+			}
+		}
+
 		imageBuckets[v.Name] = h
 	}
 
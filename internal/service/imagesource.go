@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"go-image/internal/util/utilimage"
+	"go-image/internal/util/utilstorage"
+	"go-image/internal/util/utilstring"
+	"io"
+	"path"
+	"path/filepath"
+)
+
+const (
+	defaultSourceMaxBytes     = 20 << 20 // 20MB
+	defaultSourceMaxDimension = 10000    // px width or height
+)
+
+var (
+	// ErrSourceInvalid not an image or format not supported
+	ErrSourceInvalid = errors.New("error source image not valid")
+	// ErrSourceTooLarge bytes or dimensions over bucket limit, also of http origin body
+	ErrSourceTooLarge = utilstorage.ErrTooLarge
+	// ErrSourceReadOnly originals of http origin without persist
+	ErrSourceReadOnly = utilstorage.ErrReadOnly
+)
+
+// sourceFormats decoder format => ext of original
+var sourceFormats = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"webp": ".webp",
+	"gif":  ".gif",
+	"bmp":  ".bmp",
+	"tiff": ".tif",
+}
+
+type SourceItem struct {
+	Bucket  string
+	ID      string
+	Format  string // jpeg png ...
+	Width   int
+	Height  int
+	Size    int64
+	Created bool     // false if replaced
+	Names   []string // variants 1.jpg 1.webp ...
+}
+
+func (x *bucketHandler) putSource(id string, r io.Reader) (res *SourceItem, err error) {
+
+	{
+		if !utilstring.IsValidID(id) {
+			return nil, fmt.Errorf("error image id not valid")
+		}
+		id = filepath.Clean(id) //
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, x.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := x.checkSource(data)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, ext := h.Config, h.Format, sourceFormats[h.Format]
+
+	err = x.pool.Do(func() error {
+		release, err := x.acquireDecode(h)
+		if err != nil {
+			return err
+		}
+		defer release()
+		if err := utilimage.Validate(data); err != nil {
+			return x.reject(rejectInvalid, ErrSourceInvalid, "%v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prev, err := x.sourceFile(id)
+	if err != nil {
+		return nil, err
+	}
+	created := prev == ""
+
+	sourceFile := path.Join(x.subDir(id), id+ext)
+
+	err = x.source.Put(sourceFile, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// one original per id, lookup order must not find an older one
+	for _, v := range sourceExts {
+		if v == ext {
+			continue
+		}
+		if err := x.source.Delete(path.Join(x.subDir(id), id+v)); err != nil {
+			return nil, err
+		}
+	}
+
+	count, err := x.purgeCache(id)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		x.notifyPurge(id, purgeReasonUpload, count)
+	}
+
+	res = &SourceItem{
+		Bucket:  x.Name,
+		ID:      id,
+		Format:  format,
+		Width:   cfg.Width,
+		Height:  cfg.Height,
+		Size:    int64(len(data)),
+		Created: created,
+	}
+
+	for _, spec := range x.variants() {
+		for _, ext := range spec.exts() {
+			res.Names = append(res.Names, spec.Name+ext)
+		}
+	}
+
+	return res, nil
+}
+
+func (x *defaultImageSizeSrv) PutSource(bucket string, id string, r io.Reader) (*SourceItem, error) {
+
+	h := x.bucketHandlers[bucket]
+	if h == nil {
+		return nil, fmt.Errorf("error no bucket: %s", bucket)
+	}
+
+	return h.putSource(id, r)
+}
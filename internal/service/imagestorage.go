@@ -0,0 +1,102 @@
+package service
+
+import (
+	"fmt"
+	"go-image/internal/config"
+	"go-image/internal/util/utilfile"
+	xlog "go-image/internal/util/utillog"
+	"go-image/internal/util/utilstorage"
+	"net/http"
+	"os"
+	"time"
+)
+
+// storage types of bucket source and cache
+const (
+	storageFS   = "fs"
+	storageS3   = "s3"
+	storageHTTP = "http" // origin, source only
+)
+
+const (
+	storageTimeout = 60 // sec, of one s3 request
+
+	defaultOriginTimeout     = 10 // sec
+	defaultOriginNotFoundTTL = 60 // sec
+	defaultOriginRevalidate  = 60 // sec
+)
+
+// originContentTypes default of origin content_types
+var originContentTypes = []string{"image/jpeg", "image/png", "image/webp", "image/gif", "image/bmp", "image/tiff"}
+
+// newStorage of cfg, dir is the root of fs storage (created if not exists),
+// maxBytes is the body limit of http origin
+func newStorage(cfg config.AppConfigStorage, dir string, maxBytes int64) (utilstorage.Storage, error) {
+
+	switch cfg.Type {
+	case "", storageFS:
+		if !utilfile.DirExists(dir) {
+			xlog.Warn("image dir no exists %s", dir)
+			if err := utilfile.MakeAllDirs(dir); err != nil {
+				return nil, err
+			}
+		}
+		return utilstorage.NewFS(dir), nil
+	case storageS3:
+		opts := utilstorage.S3Options{
+			Endpoint:  cfg.Endpoint,
+			Region:    cfg.Region,
+			Bucket:    cfg.Bucket,
+			Prefix:    cfg.Prefix,
+			AccessKey: cfg.AccessKey,
+			SecretKey: cfg.SecretKey,
+		}
+		if opts.AccessKey == "" {
+			opts.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if opts.SecretKey == "" {
+			opts.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		// http.DefaultTransport, tuned by mustConfigRuntime
+		return utilstorage.NewS3(opts, &http.Client{Timeout: storageTimeout * time.Second})
+	case storageHTTP:
+		return newOrigin(cfg, dir, maxBytes)
+	}
+
+	return nil, fmt.Errorf("error storage type not valid: %v", cfg.Type)
+}
+
+func newOrigin(cfg config.AppConfigStorage, dir string, maxBytes int64) (utilstorage.Storage, error) {
+
+	if cfg.Timeout < 1 {
+		cfg.Timeout = defaultOriginTimeout
+	}
+	if cfg.NotFoundTTL < 1 {
+		cfg.NotFoundTTL = defaultOriginNotFoundTTL
+	}
+	if cfg.Revalidate < 1 {
+		cfg.Revalidate = defaultOriginRevalidate
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = originContentTypes
+	}
+
+	opts := utilstorage.HTTPOptions{
+		URL:          cfg.URL,
+		MaxBytes:     maxBytes,
+		ContentTypes: cfg.ContentTypes,
+		NotFoundTTL:  time.Duration(cfg.NotFoundTTL) * time.Second,
+		Revalidate:   time.Duration(cfg.Revalidate) * time.Second,
+	}
+
+	if cfg.Persist {
+		local, err := newStorage(config.AppConfigStorage{}, dir, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		opts.Local = local
+	}
+
+	// http.DefaultTransport, tuned by mustConfigRuntime
+	return utilstorage.NewHTTP(opts, &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second})
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"fmt"
+	"go-image/internal/config"
+	"go-image/internal/util/utilimage"
+	"go-image/internal/util/utilstring"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// preset watermark modes
+const (
+	watermarkAuto   = "auto" // if box > bucket watermark_after
+	watermarkAlways = "always"
+	watermarkNever  = "never"
+)
+
+// variantSpec how a variant is created, cache file id#Name.ext
+type variantSpec struct {
+	Name      string // "2" size number, preset name or dynamic "300x200-cover-north"
+	Transform utilimage.TransformOptions
+	Quality   int
+	Ext       string // fixed format of preset, "" any of imageFormats
+	Watermark bool
+	Filters   []string // utilimage.ParseFilter, after resize
+	Key       string   // settings fingerprint, stored in cache meta
+}
+
+// exts formats the variant is served in
+func (x *variantSpec) exts() []string {
+
+	if x.Ext != "" {
+		return []string{x.Ext}
+	}
+	return variantExts
+}
+
+func (x *bucketHandler) newVariantSpec(name string, t utilimage.TransformOptions, quality int, ext string, watermark bool, filters ...string) *variantSpec {
+
+	t.Resample = x.Resample
+	t.ScaledDecode = x.ScaledDecode
+	t.ConvertSRGB = x.ICC == iccConvert
+
+	res := &variantSpec{
+		Name:      name,
+		Transform: t,
+		Quality:   quality,
+		Ext:       ext,
+		Watermark: watermark && x.watermark != nil,
+		Filters:   filters,
+	}
+
+	wm := ""
+	if res.Watermark {
+		wm = x.watermarkKey
+	}
+	// "oriented": variants of sources before EXIF orientation support are stale
+	res.Key = fmt.Sprintf("%dx%d,%s,%s,%s,%s,%t,q%d,%q,%s,%s,icc:%s,%t,anim:%d,%d,%t,filters:%s,oriented",
+		t.Width, t.Height, t.Fit, t.Gravity, colorHex(t.Background), t.Resample, t.ScaledDecode, quality, wm, colorHex(x.Background), x.Metadata, x.ICC, x.EmbedSRGB,
+		x.animation.MaxFrames, x.animation.MaxPixels, x.AnimatedWebP, strings.Join(filters, "|"))
+
+	return res
+}
+
+// pipeline ops of spec for data: orientation, resize, then frameOps
+func (x *bucketHandler) pipeline(data []byte, spec *variantSpec) *utilimage.Pipeline {
+
+	return &utilimage.Pipeline{Ops: append(utilimage.TransformOps(data, &spec.Transform), x.frameOps(spec)...)}
+}
+
+// frameOps of spec after resize: filters, watermark
+func (x *bucketHandler) frameOps(spec *variantSpec) []utilimage.Op {
+
+	res := []utilimage.Op{}
+	for _, f := range spec.Filters {
+		op, err := utilimage.ParseFilter(f)
+		if err == nil { // validated in addPreset
+			res = append(res, op)
+		}
+	}
+	if spec.Watermark {
+		res = append(res, utilimage.WatermarkOp{Watermark: x.watermark})
+	}
+	return res
+}
+
+// colorHex "rrggbbaa" not premultiplied, "" if nil
+func colorHex(c color.Color) string {
+
+	if c == nil {
+		return ""
+	}
+	v := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("%02x%02x%02x%02x", v.R, v.G, v.B, v.A)
+}
+
+// variant spec of size number "1".."SizeCount", preset name
+// or dynamic size, nil if not exists
+func (x *bucketHandler) variant(name string) *variantSpec {
+
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 1 || n > x.SizeCount {
+			return nil
+		}
+		size := n * x.SizeStep
+		return x.newVariantSpec(
+			strconv.Itoa(n),
+			utilimage.TransformOptions{Width: size, Height: size, Fit: utilimage.FitContain},
+			x.Quality,
+			"",
+			size > x.WatermarkAfter,
+		)
+	}
+
+	if spec := x.presets[name]; spec != nil {
+		return spec
+	}
+
+	if x.DynamicSize {
+		return x.dynamicVariant(name)
+	}
+
+	return nil
+}
+
+// dynamicVariant "WxH[-fit][-gravity][-rrggbb[aa]]" in any token order,
+// spec.Name is canonical so equal options share one cache file
+func (x *bucketHandler) dynamicVariant(name string) *variantSpec {
+
+	tokens := strings.Split(name, "-")
+
+	t := utilimage.TransformOptions{Fit: utilimage.FitContain, Gravity: utilimage.GravityCenter}
+	{
+		w, h, ok := strings.Cut(tokens[0], "x")
+		if !ok {
+			return nil
+		}
+		var err error
+		if t.Width, err = strconv.Atoi(w); err != nil {
+			return nil
+		}
+		if t.Height, err = strconv.Atoi(h); err != nil {
+			return nil
+		}
+		if t.Width < 0 || t.Height < 0 || t.Width+t.Height == 0 ||
+			t.Width > x.DynamicMax || t.Height > x.DynamicMax {
+			return nil
+		}
+	}
+
+	var fit, gravity, pad string
+	for _, token := range tokens[1:] {
+		switch {
+		case fit == "" && utilimage.IsFit(token):
+			fit = token
+		case gravity == "" && utilimage.IsGravity(token):
+			gravity = token
+		case pad == "" && (len(token) == 6 || len(token) == 8):
+			c, err := utilimage.ParseColor(token)
+			if err != nil {
+				return nil
+			}
+			t.Background = c
+			pad = colorHex(c)
+		default:
+			return nil // unknown or repeated
+		}
+	}
+
+	// canonical name, defaults and unused options dropped
+	canonical := fmt.Sprintf("%dx%d", t.Width, t.Height)
+	{
+		if fit != "" && fit != utilimage.FitContain {
+			t.Fit = fit
+			canonical += "-" + fit
+		}
+		if t.Fit == utilimage.FitCover || t.Fit == utilimage.FitPad {
+			if gravity == utilimage.GravitySmart && t.Fit != utilimage.FitCover {
+				gravity = "" // cover only
+			}
+			if gravity != "" && gravity != utilimage.GravityCenter {
+				t.Gravity = gravity
+				canonical += "-" + gravity
+			}
+		}
+		if t.Fit == utilimage.FitPad && pad != "" {
+			canonical += "-" + pad
+		} else {
+			t.Background = nil
+		}
+	}
+
+	return x.newVariantSpec(canonical, t, x.Quality, "", max(t.Width, t.Height) > x.WatermarkAfter)
+}
+
+// variants size numbers then presets
+func (x *bucketHandler) variants() []*variantSpec {
+
+	res := []*variantSpec{}
+	for i := 1; i <= x.SizeCount; i++ {
+		res = append(res, x.variant(strconv.Itoa(i)))
+	}
+
+	return append(res, x.presetList...)
+}
+
+// addPreset validate config preset
+func (x *bucketHandler) addPreset(v config.AppConfigImagePreset) error {
+
+	if !utilstring.IsValidID(v.Name) {
+		return fmt.Errorf("error preset name not valid: %v", v.Name)
+	}
+	if x.presets[v.Name] != nil {
+		return fmt.Errorf("error preset name duplicated: %v", v.Name)
+	}
+	if v.Width < 0 || v.Height < 0 || v.Width+v.Height == 0 {
+		return fmt.Errorf("error preset %v width or height not valid", v.Name)
+	}
+	if v.Width > x.MaxDimension || v.Height > x.MaxDimension {
+		return fmt.Errorf("error preset %v width or height over max_dimension", v.Name)
+	}
+
+	t := utilimage.TransformOptions{Width: v.Width, Height: v.Height, Fit: v.Fit, Gravity: v.Gravity}
+	{
+		if t.Fit == "" {
+			t.Fit = utilimage.FitContain
+		}
+		if !utilimage.IsFit(t.Fit) {
+			return fmt.Errorf("error preset %v fit not valid: %v", v.Name, v.Fit)
+		}
+
+		if t.Gravity == "" {
+			t.Gravity = utilimage.GravityCenter
+		}
+		t.Gravity = strings.ReplaceAll(t.Gravity, "-", "") // south-east
+		if !utilimage.IsGravity(t.Gravity) {
+			return fmt.Errorf("error preset %v gravity not valid: %v", v.Name, v.Gravity)
+		}
+		if t.Gravity == utilimage.GravitySmart && t.Fit != utilimage.FitCover {
+			return fmt.Errorf("error preset %v gravity smart needs fit cover", v.Name)
+		}
+
+		if v.PadColor != "" {
+			c, err := utilimage.ParseColor(v.PadColor)
+			if err != nil {
+				return fmt.Errorf("error preset %v pad_color not valid: %v", v.Name, v.PadColor)
+			}
+			t.Background = c
+		}
+	}
+
+	ext := ""
+	if v.Format != "" {
+		ext = "." + v.Format
+		if _, ok := imageFormats[ext]; !ok {
+			return fmt.Errorf("error preset %v format not valid: %v", v.Name, v.Format)
+		}
+	}
+
+	quality := v.Quality
+	if quality < 1 || quality > 100 {
+		quality = x.Quality
+	}
+
+	watermark := false
+	switch v.Watermark {
+	case "", watermarkAuto:
+		watermark = max(v.Width, v.Height) > x.WatermarkAfter
+	case watermarkAlways:
+		watermark = true
+	case watermarkNever:
+	default:
+		return fmt.Errorf("error preset %v watermark not valid: %v", v.Name, v.Watermark)
+	}
+
+	for _, f := range v.Filters {
+		if _, err := utilimage.ParseFilter(f); err != nil {
+			return fmt.Errorf("error preset %v: %v", v.Name, err)
+		}
+	}
+
+	spec := x.newVariantSpec(v.Name, t, quality, ext, watermark, v.Filters...)
+
+	x.presets[v.Name] = spec
+	x.presetList = append(x.presetList, spec)
+
+	return nil
+}
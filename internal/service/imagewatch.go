@@ -0,0 +1,96 @@
+package service
+
+import (
+	"go-image/internal/util/utilfile"
+	xlog "go-image/internal/util/utillog"
+	"go-image/internal/util/utilstring"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSource purge cache variants when an original changes or is deleted,
+// fsnotify is not recursive: one watch per source sub dir
+func (x *bucketHandler) watchSource() error {
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := x.watchDirTree(w, x.Source); err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	go x.watchLoop(w)
+
+	return nil
+}
+
+func (x *bucketHandler) watchDirTree(w *fsnotify.Watcher, dir string) error {
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return w.Add(path)
+		}
+		return nil
+	})
+}
+
+func (x *bucketHandler) watchLoop(w *fsnotify.Watcher) {
+
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			x.onSourceEvent(w, ev)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			xlog.Error("bucket %v watch source: %v", x.Name, err)
+		}
+	}
+}
+
+func (x *bucketHandler) onSourceEvent(w *fsnotify.Watcher, ev fsnotify.Event) {
+
+	if ev.Has(fsnotify.Create) && utilfile.DirExists(ev.Name) {
+		if err := x.watchDirTree(w, ev.Name); err != nil {
+			xlog.Error("bucket %v watch source: %v", x.Name, err)
+		}
+		return
+	}
+
+	if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Remove) && !ev.Has(fsnotify.Rename) {
+		return
+	}
+
+	ext := filepath.Ext(ev.Name)
+	if !slices.Contains(sourceExts, ext) {
+		return
+	}
+
+	id := strings.TrimSuffix(filepath.Base(ev.Name), ext)
+	if !utilstring.IsValidID(id) {
+		return
+	}
+
+	count, err := x.purgeCache(id)
+	if err != nil {
+		xlog.Error("bucket %v purge %v: %v", x.Name, id, err)
+	}
+	if count > 0 {
+		xlog.Info("bucket %v source %v %v, purged: %v", x.Name, ev.Op, id, count)
+		x.notifyPurge(id, purgeReasonWatch, count)
+	}
+}
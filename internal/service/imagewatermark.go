@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"go-image/internal/config"
+	"go-image/internal/util/utilimage"
+	"hash/crc32"
+	"image"
+	"os"
+	"strings"
+)
+
+// setWatermark bucket watermark of text (water_mark) and style, nil if no text and no logo;
+// watermarkKey changes with style, logo or font data (cached variants are regenerated)
+func (x *bucketHandler) setWatermark(text string, v config.AppConfigImageWatermark) error {
+
+	x.watermark, x.watermarkKey = nil, ""
+	if text == "" && v.Logo == "" {
+		return nil
+	}
+
+	w := &utilimage.WatermarkOptions{
+		Text:     text,
+		FontSize: v.FontSize,
+		Scale:    v.Scale,
+		Position: strings.ReplaceAll(v.Position, "-", ""), // south-east
+		Margin:   v.Margin,
+		Opacity:  v.Opacity,
+		Rotation: v.Rotation,
+		Mode:     v.Mode,
+	}
+	hash := crc32.NewIEEE()
+
+	if w.Position == "" {
+		w.Position = utilimage.GravityCenter
+	}
+	if !utilimage.IsGravity(w.Position) || w.Position == utilimage.GravitySmart {
+		return fmt.Errorf("error watermark position not valid: %v", v.Position)
+	}
+	if !utilimage.IsWatermarkMode(w.Mode) {
+		return fmt.Errorf("error watermark mode not valid: %v", v.Mode)
+	}
+	if w.Opacity < 0 || w.Opacity > 1 || w.Scale < 0 || w.Scale > 1 || w.Margin < 0 {
+		return fmt.Errorf("error watermark opacity, scale (0..1) or margin not valid")
+	}
+
+	if v.Color != "" {
+		c, err := utilimage.ParseColor(v.Color)
+		if err != nil {
+			return fmt.Errorf("error watermark color not valid: %v", v.Color)
+		}
+		w.Color = c
+	}
+
+	if v.Logo != "" {
+		data, err := os.ReadFile(v.Logo)
+		if err != nil {
+			return fmt.Errorf("error watermark logo: %v", err)
+		}
+		w.Logo, _, err = image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("error watermark logo not valid: %v", err)
+		}
+		_, _ = hash.Write(data)
+	}
+
+	if v.Font != "" {
+		data, err := os.ReadFile(v.Font)
+		if err != nil {
+			return fmt.Errorf("error watermark font: %v", err)
+		}
+		w.Font, err = utilimage.ParseFont(data)
+		if err != nil {
+			return err
+		}
+		_, _ = hash.Write(data)
+	}
+
+	x.watermark = w
+	x.watermarkKey = fmt.Sprintf("%q,%+v,%08x", text, v, hash.Sum32())
+	return nil
+}
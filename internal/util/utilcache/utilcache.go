@@ -0,0 +1,231 @@
+// Package utilcache in-memory cache bounded by total bytes, LRU eviction with TinyLFU admission:
+// a new item evicts others only if it is requested more often than the evicted
+package utilcache
+
+import (
+	"container/list"
+	"hash/maphash"
+	"strings"
+	"sync"
+)
+
+type entry struct {
+	key   string
+	value any
+	size  int64
+}
+
+// Cache safe for concurrent use
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	maxItem  int64 // bytes, larger are not admitted
+	size     int64
+	items    map[string]*list.Element
+	lru      *list.List // front is recent
+	sketch   *sketch
+}
+
+// New cache of maxBytes, items over maxItem bytes are not cached (0 is maxBytes/8);
+// counters is the expected number of distinct keys of the frequency sketch
+func New(maxBytes int64, maxItem int64, counters int) *Cache {
+
+	if maxItem <= 0 {
+		maxItem = maxBytes / 8
+	}
+	return &Cache{
+		maxBytes: maxBytes,
+		maxItem:  min(maxItem, maxBytes),
+		items:    map[string]*list.Element{},
+		lru:      list.New(),
+		sketch:   newSketch(counters),
+	}
+}
+
+// Get value of key, the request is counted for admission (hit or miss)
+func (x *Cache) Get(key string) (any, bool) {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.sketch.add(key)
+
+	e, ok := x.items[key]
+	if !ok {
+		return nil, false
+	}
+	x.lru.MoveToFront(e)
+	return e.Value.(*entry).value, true
+}
+
+// victims LRU entries freeing room for size, nil if not admitted
+func (x *Cache) victims(key string, size int64) (res []*list.Element, ok bool) {
+
+	if size > x.maxItem {
+		return nil, false
+	}
+
+	free := x.maxBytes - x.size
+	if e, ok := x.items[key]; ok {
+		free += e.Value.(*entry).size // replaced
+	}
+
+	freq := x.sketch.estimate(key)
+	for e := x.lru.Back(); free < size && e != nil; e = e.Prev() {
+		v := e.Value.(*entry)
+		if v.key == key {
+			continue
+		}
+		if x.sketch.estimate(v.key) >= freq {
+			return nil, false // victim is more popular
+		}
+		res = append(res, e)
+		free += v.size
+	}
+
+	return res, free >= size
+}
+
+// Admits Set of key with size would store it (check before loading value)
+func (x *Cache) Admits(key string, size int64) bool {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	_, ok := x.victims(key, size)
+	return ok
+}
+
+// Set store value of size bytes if admitted, evicted is the count of removed entries
+func (x *Cache) Set(key string, value any, size int64) (admitted bool, evicted int) {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	victims, ok := x.victims(key, size)
+	if !ok {
+		return false, 0
+	}
+	for _, e := range victims {
+		x.remove(e)
+	}
+
+	if e, ok := x.items[key]; ok {
+		x.remove(e)
+	}
+	x.items[key] = x.lru.PushFront(&entry{key: key, value: value, size: size})
+	x.size += size
+
+	return true, len(victims)
+}
+
+func (x *Cache) remove(e *list.Element) {
+
+	v := e.Value.(*entry)
+	x.lru.Remove(e)
+	delete(x.items, v.key)
+	x.size -= v.size
+}
+
+// Delete key, false if not cached
+func (x *Cache) Delete(key string) bool {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	e, ok := x.items[key]
+	if ok {
+		x.remove(e)
+	}
+	return ok
+}
+
+// DeletePrefix keys starting with prefix, count of removed
+func (x *Cache) DeletePrefix(prefix string) int {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	count := 0
+	for k, e := range x.items {
+		if strings.HasPrefix(k, prefix) {
+			x.remove(e)
+			count++
+		}
+	}
+	return count
+}
+
+// Bytes total size of cached values
+func (x *Cache) Bytes() int64 {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.size
+}
+
+// Len count of cached values
+func (x *Cache) Len() int {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.items)
+}
+
+// sketch count-min of 4 rows, 4 bit counters; halved after 10 x width additions (aging)
+type sketch struct {
+	rows  [4][]uint8
+	mask  uint64
+	seed  maphash.Seed
+	added int
+	reset int
+}
+
+func newSketch(counters int) *sketch {
+
+	width := 16
+	for width < counters {
+		width *= 2
+	}
+	res := &sketch{mask: uint64(width - 1), seed: maphash.MakeSeed(), reset: 10 * width}
+	for i := range res.rows {
+		res.rows[i] = make([]uint8, width)
+	}
+	return res
+}
+
+// index of key in row i, halves of one 64 bit hash (double hashing)
+func (x *sketch) index(h uint64, i int) uint64 {
+
+	return (h + uint64(i)*(h>>32|h<<32|1)) & x.mask
+}
+
+func (x *sketch) add(key string) {
+
+	h := maphash.String(x.seed, key)
+	for i := range x.rows {
+		if c := &x.rows[i][x.index(h, i)]; *c < 15 {
+			*c++
+		}
+	}
+
+	x.added++
+	if x.added >= x.reset {
+		for i := range x.rows {
+			for j := range x.rows[i] {
+				x.rows[i][j] /= 2
+			}
+		}
+		x.added /= 2
+	}
+}
+
+func (x *sketch) estimate(key string) uint8 {
+
+	h := maphash.String(x.seed, key)
+	res := uint8(15)
+	for i := range x.rows {
+		res = min(res, x.rows[i][x.index(h, i)])
+	}
+	return res
+}
@@ -0,0 +1,77 @@
+package utilcache
+
+import (
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+
+	c := New(100, 50, 64)
+
+	// room, admitted
+	for _, k := range []string{"a", "b", "c"} {
+		if ok, evicted := c.Set(k, k, 30); !ok || evicted != 0 {
+			t.Fatalf("set %v: %v %v", k, ok, evicted)
+		}
+	}
+	if v, ok := c.Get("a"); !ok || v != "a" {
+		t.Fatalf("get a: %v %v", v, ok)
+	}
+	if ok, _ := c.Set("big", "big", 51); ok {
+		t.Fatal("over max item admitted")
+	}
+
+	// d is new, LRU b is requested once: not admitted
+	if c.Admits("d", 30) {
+		t.Fatal("d admitted")
+	}
+
+	// d requested more often than b
+	for i := 0; i < 3; i++ {
+		c.Get("d")
+	}
+	if ok, evicted := c.Set("d", "d", 30); !ok || evicted != 1 {
+		t.Fatalf("set d: %v %v", ok, evicted)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b not evicted")
+	}
+	if c.Len() != 3 || c.Bytes() != 90 {
+		t.Fatalf("len %v bytes %v", c.Len(), c.Bytes())
+	}
+
+	// replace keeps size
+	if ok, _ := c.Set("a", "a2", 40); !ok || c.Bytes() != 100 {
+		t.Fatalf("replace %v %v", ok, c.Bytes())
+	}
+
+	if !c.Delete("a") || c.Delete("a") {
+		t.Fatal("delete")
+	}
+	if n := c.DeletePrefix("d"); n != 1 || c.Len() != 1 {
+		t.Fatalf("delete prefix %v len %v", n, c.Len())
+	}
+}
+
+func TestSketch(t *testing.T) {
+
+	s := newSketch(16)
+
+	for i := 0; i < 5; i++ {
+		s.add("hot")
+	}
+	s.add("cold")
+	if s.estimate("hot") < 5 || s.estimate("cold") < 1 || s.estimate("none") > 1 {
+		t.Fatalf("hot %v cold %v none %v", s.estimate("hot"), s.estimate("cold"), s.estimate("none"))
+	}
+
+	// aging halves counters
+	before := s.estimate("hot")
+	for s.added < s.reset-1 {
+		s.add("other")
+	}
+	s.add("other")
+	if after := s.estimate("hot"); after >= before {
+		t.Fatalf("not aged %v %v", before, after)
+	}
+}
@@ -0,0 +1,200 @@
+package utilexif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"regexp"
+	"slices"
+)
+
+// IFD0 tags kept by copyright policy
+const (
+	TagArtist    = 0x013b
+	TagCopyright = 0x8298
+)
+
+// JPEGExifPrefix and JPEGXMPPrefix of APP1 payload
+var (
+	JPEGExifPrefix = []byte("Exif\x00\x00")
+	JPEGXMPPrefix  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// JPEGICCPrefix of APP2 payload, followed by chunk number and count (1 based)
+var JPEGICCPrefix = []byte("ICC_PROFILE\x00")
+
+// PNGXMPKeyword of iTXt chunk, with terminating 0
+var PNGXMPKeyword = []byte("XML:com.adobe.xmp\x00")
+
+// xmpOrientationRe attribute tiff:Orientation="6" or element <tiff:Orientation>6</tiff:Orientation>
+var xmpOrientationRe = regexp.MustCompile(`(tiff:Orientation(?:="|>))[1-8]`)
+
+// Metadata raw EXIF, XMP and ICC profile of an image
+type Metadata struct {
+	Exif []byte // TIFF structure, without "Exif\0\0"
+	XMP  []byte // XMP packet
+	ICC  []byte // colour profile
+}
+
+// Read EXIF, XMP and ICC profile of jpeg, png or webp, empty if none
+func Read(r io.ReadSeeker) (*Metadata, error) {
+
+	head, err := readHead(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return readMetadata(r, head)
+}
+
+// IsEmpty no EXIF, no XMP and no ICC
+func (x *Metadata) IsEmpty() bool {
+	return x == nil || len(x.Exif) == 0 && len(x.XMP) == 0 && len(x.ICC) == 0
+}
+
+// ifdEntry IFD0 entry with its value bytes
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// typeSize bytes per value of TIFF type, 0 if unknown
+func typeSize(typ uint16) int {
+
+	switch typ {
+	case 1, 2, 6, 7: // BYTE ASCII SBYTE UNDEFINED
+		return 1
+	case 3, 8: // SHORT SSHORT
+		return 2
+	case 4, 9, 11: // LONG SLONG FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL SRATIONAL DOUBLE
+		return 8
+	}
+	return 0
+}
+
+// readIFD0 byte order, IFD0 entries and offset of each entry in tiff
+func readIFD0(tiff []byte) (binary.ByteOrder, []ifdEntry, []int) {
+
+	if len(tiff) < 8 {
+		return nil, nil, nil
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil, nil
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil, nil, nil
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+
+	entries, offsets := []ifdEntry{}, []int{}
+	for i := 0; i < count; i++ {
+		o := ifd + 2 + 12*i
+		if o+12 > len(tiff) {
+			break
+		}
+		e := ifdEntry{tag: order.Uint16(tiff[o:]), typ: order.Uint16(tiff[o+2:]), count: order.Uint32(tiff[o+4:])}
+
+		size := typeSize(e.typ) * int(e.count)
+		switch {
+		case typeSize(e.typ) == 0 || e.count > maxSegment:
+			continue
+		case size <= 4:
+			e.value = tiff[o+8 : o+8+size]
+		default:
+			v := int(order.Uint32(tiff[o+8:]))
+			if v < 0 || v+size > len(tiff) {
+				continue
+			}
+			e.value = tiff[v : v+size]
+		}
+
+		entries = append(entries, e)
+		offsets = append(offsets, o)
+	}
+
+	return order, entries, offsets
+}
+
+// SetOrientation copy of tiff with IFD0 orientation v, unchanged if tag is missing
+func SetOrientation(tiff []byte, v int) []byte {
+
+	res := slices.Clone(tiff)
+
+	order, entries, offsets := readIFD0(res)
+	for i, e := range entries {
+		if e.tag == tagOrientation && e.typ == 3 && e.count == 1 {
+			order.PutUint16(res[offsets[i]+8:], uint16(v))
+		}
+	}
+
+	return res
+}
+
+// Filter new TIFF with only IFD0 tags of list, nil if none found;
+// sub IFDs (Exif, GPS) and thumbnail are dropped
+func Filter(tiff []byte, tags ...uint16) []byte {
+
+	order, entries, _ := readIFD0(tiff)
+
+	kept := []ifdEntry{}
+	for _, e := range entries {
+		if slices.Contains(tags, e.tag) {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	slices.SortFunc(kept, func(a, b ifdEntry) int { return int(a.tag) - int(b.tag) })
+
+	b := &bytes.Buffer{}
+	{
+		b.Write(tiff[:2])
+		_ = binary.Write(b, order, uint16(42))
+		_ = binary.Write(b, order, uint32(8)) // IFD0
+	}
+
+	values := 8 + 2 + 12*len(kept) + 4 // after IFD0
+	data := []byte{}
+
+	_ = binary.Write(b, order, uint16(len(kept)))
+	for _, e := range kept {
+		_ = binary.Write(b, order, []uint16{e.tag, e.typ})
+		_ = binary.Write(b, order, e.count)
+		if len(e.value) <= 4 {
+			v := make([]byte, 4)
+			copy(v, e.value)
+			b.Write(v)
+			continue
+		}
+		_ = binary.Write(b, order, uint32(values+len(data)))
+		data = append(data, e.value...)
+		if len(data)%2 == 1 {
+			data = append(data, 0) // word aligned
+		}
+	}
+	_ = binary.Write(b, order, uint32(0)) // next IFD
+
+	b.Write(data)
+
+	return b.Bytes()
+}
+
+// SetXMPOrientation copy of xmp with tiff:Orientation v
+func SetXMPOrientation(xmp []byte, v int) []byte {
+
+	return xmpOrientationRe.ReplaceAll(xmp, []byte("${1}"+string(rune('0'+v))))
+}
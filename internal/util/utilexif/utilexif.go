@@ -0,0 +1,391 @@
+// Package utilexif EXIF, XMP and ICC profile reader, walks container headers with seeks (no pixel decoding)
+package utilexif
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// OrientationNormal no transform
+const OrientationNormal = 1
+
+const tagOrientation = 0x0112
+
+const maxSegment = 1 << 20 // exif payload limit
+
+var errNoExif = errors.New("error no exif")
+
+// Orientation EXIF orientation 1..8 of jpeg, png, webp or tiff,
+// OrientationNormal if image has no EXIF
+func Orientation(r io.ReadSeeker) (int, error) {
+
+	tiff, err := findTIFF(r)
+	if errors.Is(err, errNoExif) {
+		return OrientationNormal, nil
+	}
+	if err != nil {
+		return OrientationNormal, err
+	}
+
+	v, err := tiffTag(tiff, tagOrientation)
+	if errors.Is(err, errNoExif) {
+		return OrientationNormal, nil
+	}
+	if err != nil {
+		return OrientationNormal, err
+	}
+	if v < 1 || v > 8 {
+		return OrientationNormal, nil
+	}
+
+	return v, nil
+}
+
+// findTIFF returns reader of the EXIF TIFF structure
+func findTIFF(r io.ReadSeeker) (io.ReadSeeker, error) {
+
+	head, err := readHead(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return r, nil
+	}
+
+	m, err := readMetadata(r, head)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Exif) == 0 {
+		return nil, errNoExif
+	}
+
+	return bytes.NewReader(m.Exif), nil
+}
+
+// readHead first bytes, r is rewound
+func readHead(r io.ReadSeeker) ([]byte, error) {
+
+	head := make([]byte, 12)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return head[:n], nil
+}
+
+// readMetadata by container of head, empty if not jpeg, png or webp
+func readMetadata(r io.ReadSeeker, head []byte) (*Metadata, error) {
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8}):
+		return readJPEG(r)
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return readPNG(r)
+	case len(head) == 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return readWEBP(r)
+	}
+
+	return &Metadata{}, nil
+}
+
+func readSegment(r io.Reader, size int64) ([]byte, error) {
+
+	if size < 0 || size > maxSegment {
+		return nil, fmt.Errorf("error exif segment size: %v", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func readJPEG(r io.ReadSeeker) (*Metadata, error) {
+
+	if _, err := r.Seek(2, io.SeekStart); err != nil { // SOI
+		return nil, err
+	}
+
+	res := &Metadata{}
+	icc := map[byte][]byte{} // APP2 chunks by number
+	iccCount := byte(0)
+
+	b := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, b[:2]); err != nil {
+			return nil, err
+		}
+		if b[0] != 0xff {
+			return nil, fmt.Errorf("error jpeg marker not valid")
+		}
+		// fill bytes
+		for b[1] == 0xff {
+			if _, err := io.ReadFull(r, b[1:2]); err != nil {
+				return nil, err
+			}
+		}
+
+		marker := b[1]
+		if marker == 0xda || marker == 0xd9 { // SOS EOI, metadata is before
+			res.ICC = joinICC(icc, iccCount)
+			return res, nil
+		}
+		if marker >= 0xd0 && marker <= 0xd7 || marker == 0x01 { // no length
+			continue
+		}
+
+		if _, err := io.ReadFull(r, b[2:4]); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint16(b[2:4])) - 2
+
+		if marker == 0xe1 { // APP1
+			data, err := readSegment(r, size)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case bytes.HasPrefix(data, JPEGExifPrefix) && res.Exif == nil:
+				res.Exif = data[len(JPEGExifPrefix):]
+			case bytes.HasPrefix(data, JPEGXMPPrefix) && res.XMP == nil:
+				res.XMP = data[len(JPEGXMPPrefix):]
+			}
+			continue
+		}
+
+		if marker == 0xe2 { // APP2
+			data, err := readSegment(r, size)
+			if err != nil {
+				return nil, err
+			}
+			if p := len(JPEGICCPrefix); bytes.HasPrefix(data, JPEGICCPrefix) && len(data) > p+2 {
+				icc[data[p]] = data[p+2:]
+				iccCount = data[p+1]
+			}
+			continue
+		}
+
+		if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// joinICC APP2 chunks 1..count in order, nil if any is missing
+func joinICC(chunks map[byte][]byte, count byte) []byte {
+
+	res := []byte{}
+	for i := byte(1); i <= count && count > 0; i++ {
+		chunk, ok := chunks[i]
+		if !ok {
+			return nil
+		}
+		res = append(res, chunk...)
+		if i == 255 {
+			break
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
+func readPNG(r io.ReadSeeker) (*Metadata, error) {
+
+	if _, err := r.Seek(8, io.SeekStart); err != nil { // signature
+		return nil, err
+	}
+
+	res := &Metadata{}
+	b := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(b[:4]))
+
+		switch string(b[4:8]) {
+		case "eXIf":
+			data, err := readSegment(r, size)
+			if err != nil {
+				return nil, err
+			}
+			res.Exif = data
+			size = 0
+		case "iTXt":
+			data, err := readSegment(r, size)
+			if err != nil {
+				return nil, err
+			}
+			if xmp := pngXMP(data); xmp != nil {
+				res.XMP = xmp
+			}
+			size = 0
+		case "iCCP":
+			data, err := readSegment(r, size)
+			if err != nil {
+				return nil, err
+			}
+			res.ICC = pngICC(data)
+			size = 0
+		case "IEND":
+			return res, nil
+		}
+
+		if _, err := r.Seek(size+4, io.SeekCurrent); err != nil { // data, crc
+			return nil, err
+		}
+	}
+}
+
+// pngXMP text of uncompressed iTXt chunk with XMP keyword, nil if other
+func pngXMP(data []byte) []byte {
+
+	// keyword 0 flag method language 0 translated 0 text
+	if !bytes.HasPrefix(data, PNGXMPKeyword) || len(data) < len(PNGXMPKeyword)+2 {
+		return nil
+	}
+	data = data[len(PNGXMPKeyword):]
+	if data[0] != 0 { // compressed
+		return nil
+	}
+	data = data[2:]
+	for range 2 { // language, translated keyword
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			return nil
+		}
+		data = data[i+1:]
+	}
+	return data
+}
+
+// pngICC inflated profile of iCCP chunk: name 0 method zlib data, nil if not valid
+func pngICC(data []byte) []byte {
+
+	i := bytes.IndexByte(data, 0)
+	if i < 0 || i+2 > len(data) || data[i+1] != 0 {
+		return nil
+	}
+
+	z, err := zlib.NewReader(bytes.NewReader(data[i+2:]))
+	if err != nil {
+		return nil
+	}
+	defer z.Close()
+
+	res, err := io.ReadAll(io.LimitReader(z, maxSegment))
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+func readWEBP(r io.ReadSeeker) (*Metadata, error) {
+
+	if _, err := r.Seek(12, io.SeekStart); err != nil { // RIFF size WEBP
+		return nil, err
+	}
+
+	res := &Metadata{}
+	b := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			if errors.Is(err, io.EOF) {
+				return res, nil
+			}
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(b[4:8]))
+
+		switch string(b[:4]) {
+		case "EXIF":
+			data, err := readSegment(r, size)
+			if err != nil {
+				return nil, err
+			}
+			res.Exif = bytes.TrimPrefix(data, JPEGExifPrefix) // some writers
+			size = 0
+		case "XMP ":
+			data, err := readSegment(r, size)
+			if err != nil {
+				return nil, err
+			}
+			res.XMP = data
+			size = 0
+		case "ICCP":
+			data, err := readSegment(r, size)
+			if err != nil {
+				return nil, err
+			}
+			res.ICC = data
+			size = 0
+		}
+
+		if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil { // padded
+			return nil, err
+		}
+	}
+}
+
+// tiffTag value of SHORT or LONG tag in IFD0
+func tiffTag(r io.ReadSeeker, tag uint16) (int, error) {
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, err
+	}
+
+	var order binary.ByteOrder
+	switch string(head[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, fmt.Errorf("error tiff byte order not valid")
+	}
+
+	if _, err := r.Seek(int64(order.Uint32(head[4:8])), io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(r, b[:2]); err != nil {
+		return 0, err
+	}
+	count := int(order.Uint16(b[:2]))
+
+	for i := 0; i < count; i++ {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
+		}
+		if order.Uint16(b[:2]) != tag {
+			continue
+		}
+
+		switch order.Uint16(b[2:4]) { // type
+		case 3: // SHORT
+			return int(order.Uint16(b[8:10])), nil
+		case 4: // LONG
+			return int(order.Uint32(b[8:12])), nil
+		}
+		return 0, fmt.Errorf("error tiff tag type not valid")
+	}
+
+	return 0, errNoExif
+}
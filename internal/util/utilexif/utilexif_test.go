@@ -0,0 +1,177 @@
+package utilexif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go-image/internal/util/utiltest"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// exifTIFF TIFF structure with IFD0: ImageWidth, Orientation
+func exifTIFF(order binary.ByteOrder, orientation uint16) []byte {
+
+	b := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		b.WriteString("II")
+	} else {
+		b.WriteString("MM")
+	}
+	_ = binary.Write(b, order, uint16(42))
+	_ = binary.Write(b, order, uint32(8)) // IFD0
+	_ = binary.Write(b, order, uint16(2)) // entries
+	// ImageWidth LONG
+	_ = binary.Write(b, order, []uint16{0x0100, 4})
+	_ = binary.Write(b, order, []uint32{1, 640})
+	// Orientation SHORT
+	_ = binary.Write(b, order, []uint16{tagOrientation, 3})
+	_ = binary.Write(b, order, uint32(1))
+	_ = binary.Write(b, order, []uint16{orientation, 0})
+	_ = binary.Write(b, order, uint32(0)) // next IFD
+
+	return b.Bytes()
+}
+
+func withJPEG(tiff []byte) []byte {
+
+	jpg := utiltest.GetTestImage()
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	b := &bytes.Buffer{}
+	b.Write(jpg[:2]) // SOI
+	// APP0 JFIF-like segment first
+	b.Write([]byte{0xff, 0xe0, 0, 7, 'J', 'F', 'I', 'F', 0})
+	// APP1 XMP, skipped
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00")
+	b.Write([]byte{0xff, 0xe1})
+	_ = binary.Write(b, binary.BigEndian, uint16(len(xmp)+2))
+	b.Write(xmp)
+	b.Write([]byte{0xff, 0xe1})
+	_ = binary.Write(b, binary.BigEndian, uint16(len(payload)+2))
+	b.Write(payload)
+	b.Write(jpg[2:])
+	return b.Bytes()
+}
+
+func withPNG(t *testing.T, tiff []byte) []byte {
+
+	src := &bytes.Buffer{}
+	if err := png.Encode(src, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	data := src.Bytes()
+
+	chunk := &bytes.Buffer{}
+	_ = binary.Write(chunk, binary.BigEndian, uint32(len(tiff)))
+	chunk.WriteString("eXIf")
+	chunk.Write(tiff)
+	_ = binary.Write(chunk, binary.BigEndian, crc32.ChecksumIEEE(append([]byte("eXIf"), tiff...)))
+
+	// after IHDR (8 signature + 25 chunk)
+	return append(append(append([]byte{}, data[:33]...), chunk.Bytes()...), data[33:]...)
+}
+
+func withWEBP(tiff []byte) []byte {
+
+	b := &bytes.Buffer{}
+	b.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	b.WriteString("VP8X")
+	_ = binary.Write(b, binary.LittleEndian, uint32(10))
+	b.Write(make([]byte, 10))
+	b.WriteString("VP8 ")
+	_ = binary.Write(b, binary.LittleEndian, uint32(3))
+	b.Write([]byte{1, 2, 3, 0}) // padded
+	b.WriteString("EXIF")
+	_ = binary.Write(b, binary.LittleEndian, uint32(len(tiff)))
+	b.Write(tiff)
+	return b.Bytes()
+}
+
+func TestOrientation(t *testing.T) {
+
+	le := exifTIFF(binary.LittleEndian, 6)
+	be := exifTIFF(binary.BigEndian, 8)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"jpeg le", withJPEG(le), 6},
+		{"jpeg be", withJPEG(be), 8},
+		{"jpeg no exif", utiltest.GetTestImage(), OrientationNormal},
+		{"png", withPNG(t, le), 6},
+		{"webp", withWEBP(be), 8},
+		{"webp exif prefix", withWEBP(append([]byte("Exif\x00\x00"), le...)), 6},
+		{"tiff", le, 6},
+		{"out of range", withJPEG(exifTIFF(binary.LittleEndian, 9)), OrientationNormal},
+		{"unknown", []byte("hello"), OrientationNormal},
+		{"empty", nil, OrientationNormal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Orientation(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrientationTruncated(t *testing.T) {
+
+	data := withJPEG(exifTIFF(binary.LittleEndian, 6))
+
+	if _, err := Orientation(bytes.NewReader(data[:30])); err == nil {
+		t.Fatal("want error")
+	}
+}
+
+func TestReadFilter(t *testing.T) {
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		tiff := exifTIFF(order, 6)
+
+		m, err := Read(bytes.NewReader(withWEBP(tiff)))
+		if err != nil || !bytes.Equal(m.Exif, tiff) {
+			t.Fatalf("read %v %q", err, m.Exif)
+		}
+
+		// orientation reset
+		if got, _ := Orientation(bytes.NewReader(SetOrientation(tiff, OrientationNormal))); got != OrientationNormal {
+			t.Fatalf("SetOrientation got %v", got)
+		}
+		if got, _ := Orientation(bytes.NewReader(tiff)); got != 6 {
+			t.Fatal("SetOrientation changed source")
+		}
+
+		// only ImageWidth kept
+		filtered := Filter(tiff, 0x0100)
+		if v, err := tiffTag(bytes.NewReader(filtered), 0x0100); err != nil || v != 640 {
+			t.Fatalf("Filter width %v %v", v, err)
+		}
+		if got, _ := Orientation(bytes.NewReader(filtered)); got != OrientationNormal {
+			t.Fatalf("Filter kept orientation %v", got)
+		}
+		if Filter(tiff, TagCopyright) != nil {
+			t.Fatal("Filter of missing tag not nil")
+		}
+	}
+
+	plain := &bytes.Buffer{}
+	_ = png.Encode(plain, image.NewGray(image.Rect(0, 0, 4, 4)))
+	if m, err := Read(bytes.NewReader(plain.Bytes())); err != nil || !m.IsEmpty() {
+		t.Fatalf("no metadata: %v %v", m, err)
+	}
+
+	xmp := []byte(`<x:xmpmeta tiff:Orientation="8"><tiff:Orientation>8</tiff:Orientation></x:xmpmeta>`)
+	if got := SetXMPOrientation(xmp, 1); !bytes.Equal(got, []byte(`<x:xmpmeta tiff:Orientation="1"><tiff:Orientation>1</tiff:Orientation></x:xmpmeta>`)) {
+		t.Fatalf("SetXMPOrientation %s", got)
+	}
+}
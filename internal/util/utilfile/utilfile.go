@@ -2,10 +2,15 @@
 package utilfile
 
 import (
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// TempSuffix suffix of FileWriteAtomic temp files
+const TempSuffix = ".tmp"
+
 func DirExists(path string) bool {
 	info, err := os.Stat(path)
 	if os.IsNotExist(err) {
@@ -51,3 +56,64 @@ func FileWriteWithDir(path string, data []byte) error {
 
 	return os.WriteFile(path, data, 0600)
 }
+
+// FileWriteAtomic write to temp file in the same dir, fsync and rename,
+// readers never see a partial file
+func FileWriteAtomic(path string, data []byte) (err error) {
+
+	dir := filepath.Dir(path)
+
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*"+TempSuffix) // 0600
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	// persist rename, best effort
+	if d, e := os.Open(filepath.Clean(dir)); e == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+
+	return nil
+}
+
+// RemoveTempFiles remove orphaned FileWriteAtomic temp files in dir tree
+func RemoveTempFiles(dir string) (count int, err error) {
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), TempSuffix) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		count++
+		return nil
+	})
+
+	return count, err
+}
@@ -0,0 +1,71 @@
+package utilfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileWriteAtomic(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "id#1.jpg")
+
+	for _, data := range [][]byte{[]byte("first"), []byte("second")} {
+		if err := FileWriteAtomic(path, data); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("got %q, want %q", got, data)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temp files left: %v", entries)
+	}
+
+	if err := FileWriteAtomic(filepath.Join(dir, "no-dir", "x.jpg"), nil); err == nil {
+		t.Fatal("want error for missing dir")
+	}
+}
+
+func TestRemoveTempFiles(t *testing.T) {
+
+	dir := t.TempDir()
+
+	files := map[string]bool{ // path: removed
+		"a/id#1.jpg":                   false,
+		"a/id#1.jpg.123" + TempSuffix:  true,
+		"b/c/id#2.webp.1" + TempSuffix: true,
+		"b/id#2.webp":                  false,
+	}
+	for f := range files {
+		if err := FileWriteWithDir(filepath.Join(dir, f), []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := RemoveTempFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("count %v, want 2", count)
+	}
+
+	for f, removed := range files {
+		if FileExists(filepath.Join(dir, f)) == removed {
+			t.Errorf("%v removed %v, want %v", f, !removed, removed)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -19,6 +20,31 @@ func NewMessage(msg string) RespMessage {
 	return RespMessage{Message: msg}
 }
 
+// Accepts checks if Accept header explicitly lists the mime type (wildcards ignored)
+func Accepts(accept string, mime string) bool {
+
+	for _, part := range strings.Split(accept, ",") {
+
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), mime) {
+			continue
+		}
+
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if k == "q" {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q <= 0 {
+					return false // q=0 not acceptable
+				}
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
 // URLEncode encodes a string for safe inclusion in a URL query.
 func URLEncode(input string) string {
 	return url.QueryEscape(input)
@@ -52,6 +78,13 @@ func JoinURL(baseURL string, queryParams map[string]string) (string, error) {
 
 func PostJSON(baseURL string, queryParams map[string]string,
 	headers map[string]string, bodyJSON any,
+) ([]byte, error) {
+	return PostJSONWith(&http.Client{}, baseURL, queryParams, headers, bodyJSON)
+}
+
+// PostJSONWith PostJSON by client, for its Timeout
+func PostJSONWith(client *http.Client, baseURL string, queryParams map[string]string,
+	headers map[string]string, bodyJSON any,
 ) ([]byte, error) {
 	// The URL to send the POST request to
 	url, err := JoinURL(baseURL, queryParams)
@@ -85,7 +118,6 @@ func PostJSON(baseURL string, queryParams map[string]string,
 		}
 	}
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
 
 	if err != nil {
@@ -0,0 +1,47 @@
+package utilhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		accept string
+		mime   string
+		want   bool
+	}{
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "image/webp", true},
+		{"image/webp;q=0.9", "image/webp", true},
+		{"image/WEBP", "image/webp", true},
+		{"image/webp;q=0", "image/webp", false},
+		{"image/*,*/*;q=0.8", "image/webp", false},
+		{"", "image/webp", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := Accepts(tt.accept, tt.mime); got != tt.want {
+				t.Errorf("Accepts(%q) = %v, want %v", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostJSONWith(t *testing.T) {
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	start := time.Now()
+	_, err := PostJSONWith(&http.Client{Timeout: 50 * time.Millisecond}, slow.URL, nil, nil, map[string]string{"a": "b"})
+	if err == nil || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("timeout: %v after %v", err, time.Since(start))
+	}
+}
@@ -0,0 +1,105 @@
+package utilicc
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+var srgbProfile = sync.OnceValue(func() []byte {
+
+	// sampled sRGB transfer function
+	const n = 1024
+	curve := []byte("curv\x00\x00\x00\x00")
+	curve = binary.BigEndian.AppendUint32(curve, n)
+	for i := range n {
+		curve = binary.BigEndian.AppendUint16(curve, uint16(math.Round(65535*srgbToLinear(float64(i)/(n-1)))))
+	}
+
+	return build("sRGB", srgbMatrix, curve)
+})
+
+// SRGB compact ICC v2 sRGB profile (matrix/TRC), for embedding in output
+func SRGB() []byte {
+	return srgbProfile()
+}
+
+func appendS15Fixed16(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+}
+
+func xyzTag(v [3]float64) []byte {
+
+	res := []byte("XYZ \x00\x00\x00\x00")
+	for _, c := range v {
+		res = appendS15Fixed16(res, c)
+	}
+	return res
+}
+
+// build v2 display profile of matrix (columns r g b) and curve tag shared by rTRC gTRC bTRC
+func build(desc string, matrix [3][3]float64, curve []byte) []byte {
+
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	tags := []tag{}
+
+	{
+		// textDescriptionType: ascii, empty unicode and scriptcode
+		b := []byte("desc\x00\x00\x00\x00")
+		b = binary.BigEndian.AppendUint32(b, uint32(len(desc)+1))
+		b = append(b, desc...)
+		b = append(b, 0)
+		b = append(b, make([]byte, 4+4+2+1+67)...)
+		tags = append(tags, tag{"desc", b})
+	}
+	tags = append(tags, tag{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")})
+	tags = append(tags, tag{"wtpt", xyzTag(d50)})
+	for i, c := range []string{"r", "g", "b"} {
+		tags = append(tags, tag{c + "XYZ", xyzTag([3]float64{matrix[0][i], matrix[1][i], matrix[2][i]})})
+	}
+	for _, c := range []string{"r", "g", "b"} {
+		tags = append(tags, tag{c + "TRC", curve})
+	}
+
+	// header, tag table, data (4 byte aligned, same data is shared)
+	res := make([]byte, headerSize)
+	res = binary.BigEndian.AppendUint32(res, uint32(len(tags)))
+	table := len(res)
+	res = append(res, make([]byte, 12*len(tags))...)
+
+	offsets := map[*byte]int{}
+	for i, t := range tags {
+		offset, ok := offsets[&t.data[0]]
+		if !ok {
+			offset = len(res)
+			offsets[&t.data[0]] = offset
+			res = append(res, t.data...)
+			for len(res)%4 != 0 {
+				res = append(res, 0)
+			}
+		}
+		e := res[table+12*i:]
+		copy(e, t.sig)
+		binary.BigEndian.PutUint32(e[4:], uint32(offset))
+		binary.BigEndian.PutUint32(e[8:], uint32(len(t.data)))
+	}
+
+	h := res[:headerSize]
+	binary.BigEndian.PutUint32(h[0:], uint32(len(res)))
+	binary.BigEndian.PutUint32(h[8:], 0x02100000) // version 2.1
+	copy(h[12:], "mntr")
+	copy(h[16:], "RGB ")
+	copy(h[20:], "XYZ ")
+	binary.BigEndian.PutUint16(h[24:], 2000) // date, 2000-01-01
+	binary.BigEndian.PutUint16(h[26:], 1)
+	binary.BigEndian.PutUint16(h[28:], 1)
+	copy(h[36:], "acsp")
+	for i, c := range d50 { // illuminant
+		binary.BigEndian.PutUint32(h[68+4*i:], uint32(int32(math.Round(c*65536))))
+	}
+
+	return res
+}
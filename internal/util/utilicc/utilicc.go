@@ -0,0 +1,285 @@
+// Package utilicc ICC profile parser of RGB matrix/TRC profiles (Adobe RGB, Display P3 ...) and conversion to sRGB
+package utilicc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+)
+
+const headerSize = 128
+
+// sRGB primaries adapted to D50 (PCS), columns r g b
+var srgbMatrix = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// d50 PCS illuminant
+var d50 = [3]float64{0.9642, 1.0, 0.8249}
+
+// Profile RGB matrix/TRC profile
+type Profile struct {
+	matrix [3][3]float64            // rows X Y Z, columns r g b
+	trc    [3]func(float64) float64 // r g b to linear
+}
+
+// Parse RGB profile with XYZ PCS and rXYZ gXYZ bXYZ rTRC gTRC bTRC tags,
+// LUT based profiles are not supported
+func Parse(data []byte) (*Profile, error) {
+
+	if len(data) < headerSize+4 {
+		return nil, fmt.Errorf("error icc profile too short")
+	}
+	if string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("error icc profile signature not valid")
+	}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, fmt.Errorf("error icc profile not rgb/xyz: %q %q", data[16:20], data[20:24])
+	}
+
+	tags := map[string][]byte{}
+	{
+		count := int(binary.BigEndian.Uint32(data[headerSize:]))
+		if count > (len(data)-headerSize-4)/12 {
+			return nil, fmt.Errorf("error icc tag count not valid")
+		}
+		for i := 0; i < count; i++ {
+			e := data[headerSize+4+12*i:]
+			offset, size := binary.BigEndian.Uint32(e[4:8]), binary.BigEndian.Uint32(e[8:12])
+			if uint64(offset)+uint64(size) > uint64(len(data)) {
+				return nil, fmt.Errorf("error icc tag out of range: %q", e[:4])
+			}
+			tags[string(e[:4])] = data[offset : offset+size]
+		}
+	}
+
+	res := &Profile{}
+	for i, c := range []string{"r", "g", "b"} {
+		xyz, err := parseXYZ(tags[c+"XYZ"])
+		if err != nil {
+			return nil, err
+		}
+		for j := range 3 {
+			res.matrix[j][i] = xyz[j]
+		}
+
+		trc, err := parseCurve(tags[c+"TRC"])
+		if err != nil {
+			return nil, err
+		}
+		res.trc[i] = trc
+	}
+
+	return res, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func parseXYZ(tag []byte) ([3]float64, error) {
+
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, fmt.Errorf("error icc xyz tag not valid")
+	}
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, nil
+}
+
+// parseCurve curv (identity, gamma, table) or para (function types 0..4)
+func parseCurve(tag []byte) (func(float64) float64, error) {
+
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("error icc trc tag not valid")
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if len(tag) < 12+2*n {
+			return nil, fmt.Errorf("error icc curv size not valid")
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			g := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, g) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			// linear interpolation
+			p := x * float64(n-1)
+			i := min(int(p), n-2)
+			f := p - float64(i)
+			return table[i]*(1-f) + table[i+1]*f
+		}, nil
+
+	case "para":
+		fn := int(binary.BigEndian.Uint16(tag[8:10]))
+		counts := []int{1, 3, 4, 5, 7}
+		if fn >= len(counts) || len(tag) < 12+4*counts[fn] {
+			return nil, fmt.Errorf("error icc para not valid")
+		}
+		// g a b c d e f, missing are neutral
+		p := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for i := range counts[fn] {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		pow := func(x float64) float64 {
+			return math.Pow(max(0, a*x+b), g)
+		}
+		switch fn {
+		case 0:
+			return func(x float64) float64 { return math.Pow(x, g) }, nil
+		case 1, 2: // c is 0 for type 1
+			return func(x float64) float64 {
+				if a != 0 && x >= -b/a {
+					return pow(x) + c
+				}
+				return c
+			}, nil
+		}
+		// type 3 e f are 0
+		return func(x float64) float64 {
+			if x >= d {
+				return pow(x) + e
+			}
+			return c*x + f
+		}, nil
+	}
+
+	return nil, fmt.Errorf("error icc trc type not supported: %q", tag[:4])
+}
+
+// srgbToLinear sRGB transfer function, inverse of linearToSRGB
+func srgbToLinear(x float64) float64 {
+
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return math.Pow((x+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(x float64) float64 {
+
+	if x <= 0.0031308 {
+		return x * 12.92
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+// IsSRGB primaries and transfer functions of sRGB (conversion is no-op)
+func (x *Profile) IsSRGB() bool {
+
+	for i := range 3 {
+		for j := range 3 {
+			if math.Abs(x.matrix[i][j]-srgbMatrix[i][j]) > 0.003 {
+				return false
+			}
+		}
+		for v := 0.0; v <= 1; v += 1.0 / 16 {
+			if math.Abs(x.trc[i](v)-srgbToLinear(v)) > 0.005 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// invert 3x3 matrix, false if singular
+func invert(m [3][3]float64) ([3][3]float64, bool) {
+
+	var res [3][3]float64
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-9 {
+		return res, false
+	}
+
+	for i := range 3 {
+		for j := range 3 {
+			// cofactor of j i, transposed
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			res[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+	return res, true
+}
+
+func multiply(a [3][3]float64, b [3][3]float64) (res [3][3]float64) {
+
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				res[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return res
+}
+
+// ToSRGB convert pixels of img from profile colours to sRGB in place,
+// relative colorimetric, out of gamut is clipped
+func (x *Profile) ToSRGB(img *image.RGBA) {
+
+	inv, ok := invert(srgbMatrix)
+	if !ok {
+		return
+	}
+	m := multiply(inv, x.matrix)
+
+	// 8 bit to linear
+	var in [3][256]float64
+	for c := range 3 {
+		for v := range 256 {
+			in[c][v] = x.trc[c](float64(v) / 255)
+		}
+	}
+
+	// linear to 8 bit sRGB
+	const outSize = 4096
+	var out [outSize + 1]uint8
+	for i := range out {
+		out[i] = uint8(math.Round(255 * linearToSRGB(float64(i)/outSize)))
+	}
+	encode := func(v float64) float64 {
+		return float64(out[int(min(1, max(0, v))*outSize+0.5)])
+	}
+
+	b := img.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		p := img.Pix[img.PixOffset(b.Min.X, y):][:4*b.Dx()]
+		for i := 0; i < len(p); i += 4 {
+			a := p[i+3]
+			if a == 0 {
+				continue
+			}
+			r, g, bl := p[i], p[i+1], p[i+2]
+			if a < 255 { // premultiplied
+				r, g, bl = unpremul(r, a), unpremul(g, a), unpremul(bl, a)
+			}
+			lr, lg, lb := in[0][r], in[1][g], in[2][bl]
+			rgb := [3]float64{
+				encode(m[0][0]*lr + m[0][1]*lg + m[0][2]*lb),
+				encode(m[1][0]*lr + m[1][1]*lg + m[1][2]*lb),
+				encode(m[2][0]*lr + m[2][1]*lg + m[2][2]*lb),
+			}
+			for c := range 3 {
+				p[i+c] = uint8((rgb[c]*float64(a) + 127) / 255)
+			}
+		}
+	}
+}
+
+func unpremul(v uint8, a uint8) uint8 {
+	return uint8(min(255, (uint32(v)*255+uint32(a)/2)/uint32(a)))
+}
@@ -0,0 +1,101 @@
+package utilicc
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Display P3 primaries adapted to D50, columns r g b
+var p3Matrix = [3][3]float64{
+	{0.5151, 0.2920, 0.1571},
+	{0.2412, 0.6922, 0.0666},
+	{-0.0011, 0.0419, 0.7841},
+}
+
+// para type 3 sRGB transfer function
+func srgbPara() []byte {
+
+	b := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		b = appendS15Fixed16(b, v)
+	}
+	return b
+}
+
+func convert(p *Profile, c color.RGBA) color.RGBA {
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, c)
+	p.ToSRGB(img)
+	return img.RGBAAt(0, 0)
+}
+
+func near(a color.RGBA, b color.RGBA, d int) bool {
+
+	abs := func(v int) int { return max(v, -v) }
+	return abs(int(a.R)-int(b.R)) <= d && abs(int(a.G)-int(b.G)) <= d && abs(int(a.B)-int(b.B)) <= d && a.A == b.A
+}
+
+func TestSRGB(t *testing.T) {
+
+	data := SRGB()
+	if int(binary.BigEndian.Uint32(data)) != len(data) {
+		t.Fatalf("size %v, header %v", len(data), binary.BigEndian.Uint32(data))
+	}
+
+	p, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsSRGB() {
+		t.Fatal("sRGB profile must be sRGB")
+	}
+
+	for _, c := range []color.RGBA{{200, 100, 50, 255}, {0, 0, 0, 255}, {255, 255, 255, 255}, {64, 32, 16, 128}} {
+		if got := convert(p, c); !near(got, c, 1) {
+			t.Fatalf("%v to %v", c, got)
+		}
+	}
+}
+
+func TestToSRGB(t *testing.T) {
+
+	t.Run("p3", func(t *testing.T) {
+		p, err := Parse(build("Display P3", p3Matrix, srgbPara()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.IsSRGB() {
+			t.Fatal("p3 is not sRGB")
+		}
+		// gray is kept, white point is the same
+		if got := convert(p, color.RGBA{128, 128, 128, 255}); !near(got, color.RGBA{128, 128, 128, 255}, 1) {
+			t.Fatalf("gray %v", got)
+		}
+		// saturated colour is more saturated in sRGB
+		if got := convert(p, color.RGBA{200, 100, 50, 255}); got.R <= 200 || got.B >= 50 {
+			t.Fatalf("colour %v", got)
+		}
+	})
+
+	t.Run("linear", func(t *testing.T) {
+		p, err := Parse(build("linear", srgbMatrix, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// linear 0.5 is sRGB 188
+		if got := convert(p, color.RGBA{128, 128, 128, 255}); !near(got, color.RGBA{188, 188, 188, 255}, 1) {
+			t.Fatalf("linear %v", got)
+		}
+	})
+
+	t.Run("not valid", func(t *testing.T) {
+		for _, data := range [][]byte{nil, make([]byte, 200), SRGB()[:140]} {
+			if _, err := Parse(data); err == nil {
+				t.Fatalf("must fail: %v bytes", len(data))
+			}
+		}
+	})
+}
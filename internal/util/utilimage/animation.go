@@ -0,0 +1,221 @@
+package utilimage
+
+import (
+	"bytes"
+	"fmt"
+	"go-image/internal/util/utilwebp"
+	"image"
+	"image/color"
+	"image/gif"
+
+	"golang.org/x/image/draw"
+)
+
+// AnimationOptions limits of animated GIF sources, larger are transformed as the first frame
+type AnimationOptions struct {
+	MaxFrames int   // 0 is not limited
+	MaxPixels int64 // frames x width x height of source, 0 is not limited
+}
+
+// GIFFrames frame count and logical screen size of GIF data (block walk, no pixel decoding)
+func GIFFrames(data []byte) (frames int, width int, height int, err error) {
+
+	if len(data) < 13 || string(data[:3]) != "GIF" {
+		return 0, 0, 0, fmt.Errorf("error gif header not valid")
+	}
+	width = int(data[6]) | int(data[7])<<8
+	height = int(data[8]) | int(data[9])<<8
+
+	colorTable := func(flags byte) int {
+		if flags&0x80 == 0 {
+			return 0
+		}
+		return 3 << (flags&0x07 + 1)
+	}
+
+	// data sub-blocks from i, index after terminator
+	subBlocks := func(i int) int {
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		return i + 1
+	}
+
+	i := 13 + colorTable(data[10])
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: label, sub-blocks
+			i = subBlocks(i + 2)
+		case 0x2c: // image descriptor, local color table, lzw min code size, sub-blocks
+			if i+10 > len(data) {
+				return frames, width, height, fmt.Errorf("error gif truncated")
+			}
+			frames++
+			i = subBlocks(i + 10 + colorTable(data[i+9]) + 1)
+		case 0x3b: // trailer
+			return frames, width, height, nil
+		default:
+			return frames, width, height, fmt.Errorf("error gif block not valid: %#x", data[i])
+		}
+	}
+
+	return frames, width, height, nil
+}
+
+// TransformAnimated resize all frames of animated GIF data into FormatGIF or FormatWEBP,
+// delays and loop count are kept; frames are composited by their disposal first (coalesced),
+// ops (filters, watermark) are applied to every resized frame;
+// false if data is not an animated GIF or is over a limits (use Pipeline, first frame)
+func TransformAnimated(data []byte, t *TransformOptions, enc *EncodeOptions, ops []Op, a *AnimationOptions) ([]byte, bool, error) {
+
+	if enc.Format != FormatGIF && enc.Format != FormatWEBP {
+		return nil, false, nil
+	}
+
+	{
+		frames, width, height, err := GIFFrames(data)
+		if err != nil || frames < 2 {
+			return nil, false, nil
+		}
+		if a != nil && (a.MaxFrames > 0 && frames > a.MaxFrames ||
+			a.MaxPixels > 0 && int64(frames)*int64(width)*int64(height) > a.MaxPixels) {
+			return nil, false, nil
+		}
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	canvasRect := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	fit := FitDims(canvasRect.Dx(), canvasRect.Dy(), t)
+	crop := fit.Crop
+
+	canvas := image.NewRGBA(canvasRect)
+	var saved *image.RGBA // DisposalPrevious
+
+	frames := make([]*image.RGBA, 0, len(g.Image))
+	for i, frame := range g.Image {
+		if i > 0 {
+			// disposal of previous frame
+			prev := g.Image[i-1].Bounds()
+			switch disposal(g, i-1) {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, prev, image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				if saved != nil {
+					draw.Draw(canvas, prev, saved, prev.Min, draw.Src)
+				}
+			}
+		}
+		if disposal(g, i) == gif.DisposalPrevious {
+			saved = image.NewRGBA(canvasRect)
+			copy(saved.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		if i == 0 && t.Fit == FitCover && t.Gravity == GravitySmart {
+			crop = SmartCrop(canvas, crop.Dx(), crop.Dy()) // of first frame, fixed window
+		}
+
+		out := image.NewRGBA(image.Rect(0, 0, fit.Width, fit.Height))
+		if t.Fit == FitPad && t.Background != nil {
+			draw.Draw(out, out.Bounds(), image.NewUniform(t.Background), image.Point{}, draw.Src)
+		}
+		resample(out, fit.Dst, canvas, crop, t.Resample)
+
+		f := &Frame{Image: out, Width: fit.Width, Height: fit.Height}
+		if err := (&Pipeline{Ops: ops}).Apply(f); err != nil {
+			return nil, false, err
+		}
+
+		frames = append(frames, toRGBA(f.Image))
+	}
+
+	outBuffer := &bytes.Buffer{}
+	switch enc.Format {
+	case FormatGIF:
+		res := &gif.GIF{
+			LoopCount: g.LoopCount,
+			Config:    image.Config{Width: frames[0].Rect.Dx(), Height: frames[0].Rect.Dy()}, // after ops
+		}
+		for i, out := range frames {
+			res.Image = append(res.Image, paletted(out, g.Image[i].Palette))
+			res.Delay = append(res.Delay, g.Delay[i])
+			res.Disposal = append(res.Disposal, gif.DisposalBackground) // frames are complete
+		}
+		err = gif.EncodeAll(outBuffer, res)
+	case FormatWEBP:
+		anim := &utilwebp.Animation{LoopCount: webpLoopCount(g.LoopCount)}
+		for i, out := range frames {
+			anim.Frames = append(anim.Frames, flatten(out, enc.Background))
+			anim.Durations = append(anim.Durations, 10*g.Delay[i]) // 1/100 s
+		}
+		err = utilwebp.EncodeAll(outBuffer, anim, &utilwebp.Options{Quality: enc.Quality})
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode image: %v", err)
+	}
+
+	return outBuffer.Bytes(), true, nil
+}
+
+func disposal(g *gif.GIF, i int) byte {
+
+	if i < len(g.Disposal) {
+		return g.Disposal[i]
+	}
+	return gif.DisposalNone
+}
+
+// webpLoopCount of gif LoopCount: 0 forever, -1 once, n repeats
+func webpLoopCount(gifLoopCount int) int {
+
+	switch {
+	case gifLoopCount == 0:
+		return 0
+	case gifLoopCount < 0:
+		return 1
+	}
+	return gifLoopCount + 1
+}
+
+// paletted img in palette p of source frame, nearest colour (no dithering, no flicker),
+// transparent entry is added if img has transparent pixels
+func paletted(img *image.RGBA, p color.Palette) *image.Paletted {
+
+	pal := append(color.Palette{}, p...)
+	if len(pal) == 0 {
+		pal = append(pal, color.Black, color.White)
+	}
+
+	transparent := false
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] < 128 {
+			transparent = true
+			break
+		}
+	}
+	if transparent {
+		has := false
+		for _, c := range pal {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				has = true
+				break
+			}
+		}
+		if !has {
+			if len(pal) < 256 {
+				pal = append(pal, color.Transparent)
+			} else {
+				pal[len(pal)-1] = color.Transparent
+			}
+		}
+	}
+
+	res := image.NewPaletted(img.Rect, pal)
+	draw.Draw(res, res.Rect, img, img.Rect.Min, draw.Src)
+	return res
+}
@@ -0,0 +1,231 @@
+package utilimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"go-image/internal/util/utilexif"
+	"go-image/internal/util/utilicc"
+	"hash/crc32"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// metadata policies of output
+const (
+	MetadataStrip     = "strip"     // no EXIF, no XMP
+	MetadataCopyright = "copyright" // EXIF Artist and Copyright only
+	MetadataKeep      = "keep"      // EXIF and XMP of source
+)
+
+const jpegMaxSegment = 0xffff - 2 // APP payload limit
+
+const jpegMaxICCChunk = jpegMaxSegment - 14 // prefix, chunk number and count
+
+// IsMetadata valid policy, "" is MetadataStrip
+func IsMetadata(policy string) bool {
+
+	switch policy {
+	case "", MetadataStrip, MetadataCopyright, MetadataKeep:
+		return true
+	}
+	return false
+}
+
+// Decode image with EXIF orientation applied
+func Decode(data []byte) (image.Image, error) {
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	return Orient(img, orientation(data)), nil
+}
+
+// orientation EXIF 1..8, bad EXIF is normal
+func orientation(data []byte) int {
+
+	o, _ := utilexif.Orientation(bytes.NewReader(data))
+	return o
+}
+
+// OrientedSize width and height after EXIF orientation
+func OrientedSize(width int, height int, orientation int) (int, int) {
+
+	if orientation >= 5 && orientation <= 8 {
+		return height, width
+	}
+	return width, height
+}
+
+// Orient apply EXIF orientation 2..8, img as is for 1
+func Orient(img image.Image, orientation int) image.Image {
+
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+
+	dw, dh := OrientedSize(w, h, orientation)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// source of dst x,y
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontal
+				sx, sy = w-1-x, y
+			case 3: // rotate 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertical
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate 90 cw
+				sx, sy = y, h-1-x
+			case 7: // transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotate 90 ccw
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+
+	return dst
+}
+
+// sourceMetadata metadata of data for output by policy, nil if none;
+// orientation is reset, pixels are already oriented
+func sourceMetadata(data []byte, policy string) *utilexif.Metadata {
+
+	if policy != MetadataCopyright && policy != MetadataKeep {
+		return nil
+	}
+
+	m, err := utilexif.Read(bytes.NewReader(data))
+	if err != nil || m.IsEmpty() {
+		return nil
+	}
+
+	res := &utilexif.Metadata{}
+	switch policy {
+	case MetadataCopyright:
+		res.Exif = utilexif.Filter(m.Exif, utilexif.TagArtist, utilexif.TagCopyright)
+	case MetadataKeep:
+		if len(m.Exif) > 0 {
+			res.Exif = utilexif.SetOrientation(m.Exif, utilexif.OrientationNormal)
+		}
+		if len(m.XMP) > 0 {
+			res.XMP = utilexif.SetXMPOrientation(m.XMP, utilexif.OrientationNormal)
+		}
+	}
+
+	if res.IsEmpty() {
+		return nil
+	}
+	return res
+}
+
+// sourceProfile ICC profile of data for conversion to sRGB,
+// nil if not t.ConvertSRGB, no profile, not supported (LUT, CMYK) or sRGB
+func sourceProfile(data []byte, t *TransformOptions) *utilicc.Profile {
+
+	if !t.ConvertSRGB {
+		return nil
+	}
+
+	m, err := utilexif.Read(bytes.NewReader(data))
+	if err != nil || len(m.ICC) == 0 {
+		return nil
+	}
+
+	p, err := utilicc.Parse(m.ICC)
+	if err != nil || p.IsSRGB() {
+		return nil
+	}
+	return p
+}
+
+// embedJPEG APP1 segments and APP2 ICC chunks after SOI, too large segments are skipped
+func embedJPEG(data []byte, m *utilexif.Metadata) []byte {
+
+	segments := []byte{}
+	add := func(marker byte, prefix []byte, payload []byte) {
+		size := len(prefix) + len(payload)
+		if len(payload) == 0 || size > jpegMaxSegment {
+			return
+		}
+		segments = append(segments, 0xff, marker)
+		segments = binary.BigEndian.AppendUint16(segments, uint16(size+2))
+		segments = append(segments, prefix...)
+		segments = append(segments, payload...)
+	}
+	add(0xe1, utilexif.JPEGExifPrefix, m.Exif)
+	add(0xe1, utilexif.JPEGXMPPrefix, m.XMP)
+
+	if count := (len(m.ICC) + jpegMaxICCChunk - 1) / jpegMaxICCChunk; count <= 255 {
+		for i := 0; i < count; i++ {
+			chunk := m.ICC[i*jpegMaxICCChunk : min(len(m.ICC), (i+1)*jpegMaxICCChunk)]
+			add(0xe2, append(append([]byte{}, utilexif.JPEGICCPrefix...), byte(i+1), byte(count)), chunk)
+		}
+	}
+
+	if len(segments) == 0 || len(data) < 2 {
+		return data
+	}
+
+	res := make([]byte, 0, len(data)+len(segments))
+	res = append(res, data[:2]...) // SOI
+	res = append(res, segments...)
+	return append(res, data[2:]...)
+}
+
+// embedPNG iCCP, eXIf and iTXt chunks after IHDR
+func embedPNG(data []byte, m *utilexif.Metadata) []byte {
+
+	const ihdrEnd = 8 + 8 + 13 + 4 // signature, IHDR
+	if len(data) < ihdrEnd {
+		return data
+	}
+
+	chunks := []byte{}
+	add := func(typ string, payload []byte) {
+		chunks = binary.BigEndian.AppendUint32(chunks, uint32(len(payload)))
+		start := len(chunks)
+		chunks = append(chunks, typ...)
+		chunks = append(chunks, payload...)
+		chunks = binary.BigEndian.AppendUint32(chunks, crc32.ChecksumIEEE(chunks[start:]))
+	}
+	if len(m.ICC) > 0 {
+		// name, method deflate, zlib profile
+		z := &bytes.Buffer{}
+		z.WriteString("icc\x00\x00")
+		w := zlib.NewWriter(z)
+		w.Write(m.ICC)
+		w.Close()
+		add("iCCP", z.Bytes())
+	}
+	if len(m.Exif) > 0 {
+		add("eXIf", m.Exif)
+	}
+	if len(m.XMP) > 0 {
+		// keyword, uncompressed, method, empty language and translated keyword
+		text := append(append([]byte{}, utilexif.PNGXMPKeyword...), 0, 0, 0, 0)
+		add("iTXt", append(text, m.XMP...))
+	}
+
+	res := make([]byte, 0, len(data)+len(chunks))
+	res = append(res, data[:ihdrEnd]...)
+	res = append(res, chunks...)
+	return append(res, data[ihdrEnd:]...)
+}
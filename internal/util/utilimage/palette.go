@@ -0,0 +1,125 @@
+package utilimage
+
+import (
+	"image"
+	"image/color"
+	"slices"
+)
+
+// paletteBox median cut box of pixels
+type paletteBox struct {
+	pixels [][3]uint8
+}
+
+// channel of largest range and the range
+func (x *paletteBox) widest() (channel int, size int) {
+
+	for c := range 3 {
+		lo, hi := uint8(255), uint8(0)
+		for _, p := range x.pixels {
+			lo, hi = min(lo, p[c]), max(hi, p[c])
+		}
+		if int(hi)-int(lo) > size {
+			channel, size = c, int(hi)-int(lo)
+		}
+	}
+	return channel, size
+}
+
+func (x *paletteBox) mean() color.RGBA {
+
+	var sum [3]int
+	for _, p := range x.pixels {
+		for c := range 3 {
+			sum[c] += int(p[c])
+		}
+	}
+	n := max(1, len(x.pixels))
+	return color.RGBA{R: uint8(sum[0] / n), G: uint8(sum[1] / n), B: uint8(sum[2] / n), A: 255}
+}
+
+// Palette up to k colours of img by median cut, most frequent first (dominant colour);
+// mostly transparent pixels are skipped, pass a small image
+func Palette(img image.Image, k int) []color.RGBA {
+
+	src := toRGBA(img)
+
+	pixels := make([][3]uint8, 0, src.Rect.Dx()*src.Rect.Dy())
+	for i := 0; i+3 < len(src.Pix); i += 4 {
+		p := src.Pix[i : i+4]
+		if p[3] < 128 {
+			continue
+		}
+		// not premultiplied
+		pixels = append(pixels, [3]uint8{unpremul(p[0], p[3]), unpremul(p[1], p[3]), unpremul(p[2], p[3])})
+	}
+	if len(pixels) == 0 || k < 1 {
+		return []color.RGBA{}
+	}
+
+	boxes := []*paletteBox{{pixels: pixels}}
+	for len(boxes) < k {
+		// split box of largest range x population
+		best, bestChannel, bestScore := -1, 0, 0
+		for i, b := range boxes {
+			channel, size := b.widest()
+			if score := size * len(b.pixels); size > 0 && len(b.pixels) > 1 && score > bestScore {
+				best, bestChannel, bestScore = i, channel, score
+			}
+		}
+		if best < 0 {
+			break // all boxes are single colours
+		}
+
+		b := boxes[best]
+		slices.SortFunc(b.pixels, func(p, q [3]uint8) int {
+			return int(p[bestChannel]) - int(q[bestChannel])
+		})
+		mid := splitIndex(b.pixels, bestChannel)
+		boxes[best] = &paletteBox{pixels: b.pixels[:mid]}
+		boxes = append(boxes, &paletteBox{pixels: b.pixels[mid:]})
+	}
+
+	slices.SortStableFunc(boxes, func(p, q *paletteBox) int {
+		return len(q.pixels) - len(p.pixels)
+	})
+
+	res := make([]color.RGBA, 0, len(boxes))
+	for _, b := range boxes {
+		res = append(res, b.mean())
+	}
+	return res
+}
+
+// splitIndex change of channel value nearest to the median, pixels are sorted by channel
+func splitIndex(pixels [][3]uint8, channel int) int {
+
+	mid := len(pixels) / 2
+	for d := 0; d < len(pixels); d++ {
+		for _, i := range []int{mid - d, mid + d} {
+			if i > 0 && i < len(pixels) && pixels[i-1][channel] != pixels[i][channel] {
+				return i
+			}
+		}
+	}
+	return mid
+}
+
+// PaletteOf up to k colours of data, see Palette; decoded at 100 px like Placeholder
+func PaletteOf(data []byte, t *TransformOptions, k int) ([]color.RGBA, error) {
+
+	preview, err := previewOf(data, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return Palette(preview, k), nil
+}
+
+func unpremul(v uint8, a uint8) uint8 {
+
+	if a == 255 || a == 0 {
+		return v
+	}
+	return uint8(min(255, (uint32(v)*255+uint32(a)/2)/uint32(a)))
+}
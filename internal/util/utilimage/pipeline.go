@@ -0,0 +1,352 @@
+package utilimage
+
+import (
+	"bytes"
+	"fmt"
+	"go-image/internal/util/utilicc"
+	"go-image/internal/util/utiljpeg"
+	"image"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// filters of ParseFilter
+const (
+	FilterGrayscale = "grayscale"
+	FilterBlur      = "blur"    // blur:radius px, default 2
+	FilterSharpen   = "sharpen" // sharpen:amount, default 0.5
+)
+
+// Frame image between pipeline ops; Width, Height are of the full size image,
+// Image may be decoded smaller (JPEG DCT scaling), geometry of ops is of full size
+type Frame struct {
+	Image  image.Image
+	Width  int
+	Height int
+}
+
+// Op pipeline step, replaces f.Image
+type Op interface {
+	Apply(f *Frame) error
+}
+
+// Pipeline decoded source through Ops in order, encoded once
+type Pipeline struct {
+	Ops []Op
+}
+
+// TransformOps orientation of data and resize into box t (Transform)
+func TransformOps(data []byte, t *TransformOptions) []Op {
+
+	return []Op{
+		OrientOp{Orientation: orientation(data)},
+		ResizeOp{Transform: *t, Profile: sourceProfile(data, t)},
+	}
+}
+
+// Apply ops to f in order
+func (x *Pipeline) Apply(f *Frame) error {
+
+	for _, op := range x.Ops {
+		if err := op.Apply(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run decode data, apply ops, encode once with metadata of data by enc.Metadata;
+// JPEG is decoded scaled if the first ResizeOp (after OrientOp only) allows, see TransformOptions.ScaledDecode
+func (x *Pipeline) Run(data []byte, enc *EncodeOptions) ([]byte, error) {
+
+	var t *TransformOptions
+	o := 0
+	for _, op := range x.Ops {
+		if v, ok := op.(OrientOp); ok {
+			o = v.Orientation
+			continue
+		}
+		if v, ok := op.(ResizeOp); ok {
+			t = &v.Transform
+		}
+		break
+	}
+
+	f, err := decodeFrame(data, o, t)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := x.Apply(f); err != nil {
+		return nil, err
+	}
+
+	return encode(f.Image, enc, sourceMetadata(data, enc.Metadata), len(data))
+}
+
+// decodeFrame stored (not oriented) image of data, scaled for resize t after orientation o if t is not nil
+func decodeFrame(data []byte, o int, t *TransformOptions) (*Frame, error) {
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	scale := 1
+	if t != nil {
+		scale = decodeScale(cfg, format, o, t)
+	}
+
+	var img image.Image
+	if scale > 1 {
+		img, err = utiljpeg.DecodeScaled(bytes.NewReader(data), scale)
+	} else {
+		img, _, err = image.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	return &Frame{Image: img, Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+// fit geometry of resize t of f, crop is of f.Image (SmartCrop for FitCover with GravitySmart)
+func (f *Frame) fit(t *TransformOptions) (fit FitResult, crop image.Rectangle) {
+
+	fit = FitDims(f.Width, f.Height, t)
+
+	b := f.Image.Bounds()
+	crop = scaleCrop(fit.Crop, b, f.Width, f.Height)
+	if t.Fit == FitCover && t.Gravity == GravitySmart {
+		return fit, SmartCrop(f.Image, crop.Dx(), crop.Dy())
+	}
+	return fit, crop.Add(b.Min)
+}
+
+// OrientOp EXIF orientation 1..8, see Orient
+type OrientOp struct {
+	Orientation int
+}
+
+func (x OrientOp) Apply(f *Frame) error {
+
+	f.Image = Orient(f.Image, x.Orientation)
+	f.Width, f.Height = OrientedSize(f.Width, f.Height, x.Orientation)
+	return nil
+}
+
+// ResizeOp box and fit of Transform, Profile colours are converted to sRGB (not the pad fill)
+type ResizeOp struct {
+	Transform TransformOptions
+	Profile   *utilicc.Profile // nil none
+}
+
+func (x ResizeOp) Apply(f *Frame) error {
+
+	t := &x.Transform
+	fit, crop := f.fit(t)
+
+	res := image.NewRGBA(image.Rect(0, 0, fit.Width, fit.Height))
+
+	if t.Fit == FitPad && t.Background != nil {
+		draw.Draw(res, res.Bounds(), image.NewUniform(t.Background), image.Point{}, draw.Src)
+	}
+
+	if x.Profile != nil {
+		// converted apart, pad fill is sRGB already
+		scaled := image.NewRGBA(image.Rect(0, 0, fit.Dst.Dx(), fit.Dst.Dy()))
+		resample(scaled, scaled.Rect, f.Image, crop, t.Resample)
+		x.Profile.ToSRGB(scaled)
+		draw.Draw(res, fit.Dst, scaled, image.Point{}, draw.Over)
+	} else {
+		resample(res, fit.Dst, f.Image, crop, t.Resample)
+	}
+
+	f.Image, f.Width, f.Height = res, fit.Width, fit.Height
+	return nil
+}
+
+// CropOp rect of full size frame, clipped
+type CropOp struct {
+	Rect image.Rectangle
+}
+
+func (x CropOp) Apply(f *Frame) error {
+
+	r := x.Rect.Intersect(image.Rect(0, 0, f.Width, f.Height))
+	if r.Empty() {
+		return fmt.Errorf("error crop outside of image: %v", x.Rect)
+	}
+
+	b := f.Image.Bounds()
+	src := scaleCrop(r, b, f.Width, f.Height).Add(b.Min)
+
+	res := image.NewRGBA(image.Rect(0, 0, src.Dx(), src.Dy()))
+	draw.Draw(res, res.Rect, f.Image, src.Min, draw.Src)
+
+	f.Image, f.Width, f.Height = res, r.Dx(), r.Dy()
+	return nil
+}
+
+// WatermarkOp see DrawWatermark, none if Watermark is nil or has no Text and no Logo
+type WatermarkOp struct {
+	Watermark *WatermarkOptions
+}
+
+func (x WatermarkOp) Apply(f *Frame) error {
+
+	w := x.Watermark
+	if w == nil || w.Text == "" && w.Logo == nil {
+		return nil
+	}
+
+	res, err := DrawWatermark(f.Image, w)
+	if err != nil {
+		return fmt.Errorf("failed to add wm to image: %v", err)
+	}
+
+	f.Image = res
+	return nil
+}
+
+// GrayscaleOp luma (BT.601), alpha kept
+type GrayscaleOp struct{}
+
+func (x GrayscaleOp) Apply(f *Frame) error {
+
+	img := toRGBA(f.Image)
+	if img == f.Image {
+		img = cloneRGBA(img)
+	}
+	for i := 0; i < len(img.Pix); i += 4 {
+		p := img.Pix[i : i+3 : i+3]
+		y := uint8((299*uint32(p[0]) + 587*uint32(p[1]) + 114*uint32(p[2]) + 500) / 1000)
+		p[0], p[1], p[2] = y, y, y
+	}
+
+	f.Image = img
+	return nil
+}
+
+// BlurOp box blur of Radius px (horizontal and vertical pass), edges clamped
+type BlurOp struct {
+	Radius int
+}
+
+func (x BlurOp) Apply(f *Frame) error {
+
+	if x.Radius < 1 {
+		return nil
+	}
+	f.Image = blur(toRGBA(f.Image), x.Radius)
+	return nil
+}
+
+// SharpenOp unsharp mask of 3x3 box blur, Amount of added detail
+type SharpenOp struct {
+	Amount float64
+}
+
+func (x SharpenOp) Apply(f *Frame) error {
+
+	if x.Amount <= 0 {
+		return nil
+	}
+
+	src := toRGBA(f.Image)
+	blurred := boxBlur(src)
+	res := image.NewRGBA(src.Rect)
+	for i := range src.Pix {
+		if i%4 == 3 {
+			res.Pix[i] = src.Pix[i] // alpha
+			continue
+		}
+		v := float64(src.Pix[i]) + x.Amount*(float64(src.Pix[i])-float64(blurred.Pix[i]))
+		// premultiplied, colour not over alpha
+		res.Pix[i] = uint8(min(float64(src.Pix[i|3]), max(0, v+0.5)))
+	}
+
+	f.Image = res
+	return nil
+}
+
+// ParseFilter "grayscale", "blur" or "blur:4" (radius px 1..50), "sharpen" or "sharpen:1.5" (amount 0..5)
+func ParseFilter(s string) (Op, error) {
+
+	name, arg, hasArg := strings.Cut(s, ":")
+
+	switch name {
+	case FilterGrayscale:
+		if hasArg {
+			break
+		}
+		return GrayscaleOp{}, nil
+	case FilterBlur:
+		radius := 2
+		if hasArg {
+			v, err := strconv.Atoi(arg)
+			if err != nil || v < 1 || v > 50 {
+				break
+			}
+			radius = v
+		}
+		return BlurOp{Radius: radius}, nil
+	case FilterSharpen:
+		amount := 0.5
+		if hasArg {
+			v, err := strconv.ParseFloat(arg, 64)
+			if err != nil || v <= 0 || v > 5 {
+				break
+			}
+			amount = v
+		}
+		return SharpenOp{Amount: amount}, nil
+	}
+
+	return nil, fmt.Errorf("error filter not valid: %v", s)
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+
+	res := image.NewRGBA(src.Rect)
+	copy(res.Pix, src.Pix)
+	return res
+}
+
+// blur separable box filter of radius r, running sums, edges clamped
+func blur(src *image.RGBA, r int) *image.RGBA {
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	tmp := image.NewRGBA(src.Rect)
+	res := image.NewRGBA(src.Rect)
+
+	// pass along n pixels from offset start with step
+	pass := func(dst []uint8, s []uint8, start int, step int, n int) {
+		n1 := 2*r + 1
+		var sum [4]int
+		at := func(i int) int { return start + step*min(n-1, max(0, i)) }
+		for i := -r; i <= r; i++ {
+			for c := range 4 {
+				sum[c] += int(s[at(i)+c])
+			}
+		}
+		for i := 0; i < n; i++ {
+			o := start + step*i
+			for c := range 4 {
+				dst[o+c] = uint8((sum[c] + n1/2) / n1)
+				sum[c] += int(s[at(i+r+1)+c]) - int(s[at(i-r)+c])
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		pass(tmp.Pix, src.Pix, y*src.Stride, 4, w)
+	}
+	for x := 0; x < w; x++ {
+		pass(res.Pix, tmp.Pix, 4*x, src.Stride, h)
+	}
+
+	return res
+}
@@ -0,0 +1,372 @@
+package utilimage
+
+import (
+	"encoding/base64"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbHashMax ThumbHash input limit, px
+const thumbHashMax = 100
+
+const blurHashChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// PlaceholderResult low quality placeholders of an image
+type PlaceholderResult struct {
+	BlurHash  string // 4x3 components, 3x4 if portrait
+	ThumbHash []byte
+	Image     []byte // tiny blurred image, EncodeOptions format
+	Width     int    // of Image
+	Height    int    // of Image
+}
+
+// Placeholder BlurHash, ThumbHash and tiny blurred image (contain in t box) of data,
+// decoded once at ThumbHash size; t.ConvertSRGB, t.ScaledDecode and t.Resample apply
+func Placeholder(data []byte, t *TransformOptions, enc *EncodeOptions) (*PlaceholderResult, error) {
+
+	preview, err := previewOf(data, t)
+	if err != nil {
+		return nil, err
+	}
+	width, height := preview.Rect.Dx(), preview.Rect.Dy()
+
+	res := &PlaceholderResult{ThumbHash: ThumbHash(preview)}
+
+	if width >= height {
+		res.BlurHash = BlurHash(preview, 4, 3)
+	} else {
+		res.BlurHash = BlurHash(preview, 3, 4)
+	}
+
+	{
+		tiny := FitDims(width, height, &TransformOptions{Width: t.Width, Height: t.Height, Fit: FitContain})
+		dst := image.NewRGBA(image.Rect(0, 0, tiny.Width, tiny.Height))
+		resample(dst, dst.Rect, preview, preview.Rect, ResampleBilinear)
+
+		res.Image, err = encode(boxBlur(dst), enc, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		res.Width, res.Height = tiny.Width, tiny.Height
+	}
+
+	return res, nil
+}
+
+// previewOf data contained in 100 px (ThumbHash size), sRGB if t.ConvertSRGB;
+// t.ScaledDecode and t.Resample apply
+func previewOf(data []byte, t *TransformOptions) (*image.RGBA, error) {
+
+	small := &TransformOptions{
+		Width:        thumbHashMax,
+		Height:       thumbHashMax,
+		Fit:          FitContain,
+		Resample:     t.Resample,
+		ScaledDecode: t.ScaledDecode,
+		ConvertSRGB:  t.ConvertSRGB,
+	}
+
+	img, fit, crop, err := decodeFor(data, small)
+	if err != nil {
+		return nil, err
+	}
+
+	res := image.NewRGBA(image.Rect(0, 0, fit.Width, fit.Height))
+	resample(res, fit.Dst, img, crop, small.Resample)
+	if p := sourceProfile(data, small); p != nil {
+		p.ToSRGB(res)
+	}
+
+	return res, nil
+}
+
+// boxBlur 3x3 box filter, edges clamped
+func boxBlur(src *image.RGBA) *image.RGBA {
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(src.Rect)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum [4]int
+			n := 0
+			for yy := max(0, y-1); yy <= min(h-1, y+1); yy++ {
+				for xx := max(0, x-1); xx <= min(w-1, x+1); xx++ {
+					p := src.Pix[src.PixOffset(src.Rect.Min.X+xx, src.Rect.Min.Y+yy):]
+					for c := range 4 {
+						sum[c] += int(p[c])
+					}
+					n++
+				}
+			}
+			d := dst.Pix[dst.PixOffset(dst.Rect.Min.X+x, dst.Rect.Min.Y+y):]
+			for c := range 4 {
+				d[c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// toRGBA img as packed RGBA at 0,0, as is if already
+func toRGBA(img image.Image) *image.RGBA {
+
+	if res, ok := img.(*image.RGBA); ok && res.Rect.Min == (image.Point{}) && res.Stride == 4*res.Rect.Dx() {
+		return res
+	}
+	b := img.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(res, res.Rect, img, b.Min, draw.Src)
+	return res
+}
+
+func srgbToLinear(v uint8) float64 {
+
+	x := float64(v) / 255
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return math.Pow((x+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+
+	x := math.Max(0, math.Min(1, v))
+	if x <= 0.0031308 {
+		return int(x*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(x, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func encode83(value int, length int) string {
+
+	res := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		res[i] = blurHashChars[value%83]
+		value /= 83
+	}
+	return string(res)
+}
+
+// BlurHash of img with components 1..9 per axis (https://blurha.sh), cost is pixels x components,
+// pass a small image
+func BlurHash(img image.Image, xComponents int, yComponents int) string {
+
+	xComponents = max(1, min(9, xComponents))
+	yComponents = max(1, min(9, yComponents))
+
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	if w < 1 || h < 1 {
+		return ""
+	}
+
+	// linear pixels
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := src.Pix[src.PixOffset(x, y):]
+			linear[y*w+x] = [3]float64{srgbToLinear(p[0]), srgbToLinear(p[1]), srgbToLinear(p[2])}
+		}
+	}
+
+	// basis per component and axis
+	cosTable := func(components int, size int) [][]float64 {
+		res := make([][]float64, components)
+		for i := range res {
+			res[i] = make([]float64, size)
+			for x := range size {
+				res[i][x] = math.Cos(math.Pi * float64(i) * float64(x) / float64(size))
+			}
+		}
+		return res
+	}
+	cosX, cosY := cosTable(xComponents, w), cosTable(yComponents, h)
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				fy := normalisation * cosY[j][y]
+				for x := 0; x < w; x++ {
+					basis := cosX[i][x] * fy
+					p := linear[y*w+x]
+					for c := range 3 {
+						f[c] += basis * p[c]
+					}
+				}
+			}
+			for c := range 3 {
+				f[c] /= float64(w * h)
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	res := encode83(xComponents-1+(yComponents-1)*9, 1)
+
+	maximum := 1.0
+	if ac := factors[1:]; len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			for _, v := range f {
+				actual = math.Max(actual, math.Abs(v))
+			}
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		res += encode83(quantised, 1)
+	} else {
+		res += encode83(0, 1)
+	}
+
+	dc := factors[0]
+	res += encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+
+	for _, f := range factors[1:] {
+		v := 0
+		for _, c := range f {
+			q := c / maximum
+			signPow := math.Copysign(math.Pow(math.Abs(q), 0.5), q)
+			v = v*19 + int(math.Max(0, math.Min(18, math.Floor(signPow*9+9.5))))
+		}
+		res += encode83(v, 2)
+	}
+
+	return res
+}
+
+// ThumbHash of img (https://evanw.github.io/thumbhash), larger than 100x100 is scaled down first
+func ThumbHash(img image.Image) []byte {
+
+	src := toRGBA(img)
+	if w, h := src.Rect.Dx(), src.Rect.Dy(); w > thumbHashMax || h > thumbHashMax {
+		fit := FitDims(w, h, &TransformOptions{Width: thumbHashMax, Height: thumbHashMax, Fit: FitContain})
+		dst := image.NewRGBA(image.Rect(0, 0, fit.Width, fit.Height))
+		resample(dst, dst.Rect, src, src.Rect, ResampleBilinear)
+		src = dst
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	if w < 1 || h < 1 {
+		return nil
+	}
+	n := w * h
+	round := func(v float64) int { return int(math.Floor(v + 0.5)) }
+
+	// average colour, RGBA is premultiplied
+	var avgR, avgG, avgB, avgA float64
+	for i := 0; i < n; i++ {
+		p := src.Pix[4*i:]
+		avgR += float64(p[0]) / 255
+		avgG += float64(p[1]) / 255
+		avgB += float64(p[2]) / 255
+		avgA += float64(p[3]) / 255
+	}
+	if avgA > 0 {
+		avgR, avgG, avgB = avgR/avgA, avgG/avgA, avgB/avgA
+	}
+
+	hasAlpha := avgA < float64(n)
+	lLimit := 7 // fewer luminance bits with alpha
+	if hasAlpha {
+		lLimit = 5
+	}
+	lx := max(1, round(float64(lLimit*w)/float64(max(w, h))))
+	ly := max(1, round(float64(lLimit*h)/float64(max(w, h))))
+
+	// LPQA, composited atop the average colour
+	l, p, q, a := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := 0; i < n; i++ {
+		px := src.Pix[4*i:]
+		alpha := float64(px[3]) / 255
+		r := avgR*(1-alpha) + float64(px[0])/255
+		g := avgG*(1-alpha) + float64(px[1])/255
+		b := avgB*(1-alpha) + float64(px[2])/255
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	// DCT into DC and normalized AC
+	encodeChannel := func(channel []float64, nx int, ny int) (dc float64, ac []float64, scale float64) {
+		fx := make([]float64, w)
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				f := 0.0
+				for x := 0; x < w; x++ {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+				}
+				for y := 0; y < h; y++ {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+					for x := 0; x < w; x++ {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(n)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = math.Max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+
+	lDC, lAC, lScale := encodeChannel(l, max(3, lx), max(3, ly))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+
+	header24 := round(63*lDC) | round(31.5+31.5*pDC)<<6 | round(31.5+31.5*qDC)<<12 | round(31*lScale)<<18
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	header16 := lx | round(63*pScale)<<3 | round(63*qScale)<<9
+	if w > h { // landscape
+		header16 = ly | round(63*pScale)<<3 | round(63*qScale)<<9 | 1<<15
+	}
+	res := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+
+	channels := [][]float64{lAC, pAC, qAC}
+	if hasAlpha {
+		aDC, aAC, aScale := encodeChannel(a, 5, 5)
+		res = append(res, byte(round(15*aDC)|round(15*aScale)<<4))
+		channels = append(channels, aAC)
+	}
+
+	// AC nibbles
+	start := len(res)
+	index := 0
+	for _, ac := range channels {
+		for _, f := range ac {
+			if start+index>>1 >= len(res) {
+				res = append(res, 0)
+			}
+			res[start+index>>1] |= byte(round(15*f) << ((index & 1) << 2))
+			index++
+		}
+	}
+
+	return res
+}
+
+// DataURI data:mime;base64 of data
+func DataURI(mime string, data []byte) string {
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
@@ -0,0 +1,151 @@
+package utilimage
+
+import (
+	"go-image/internal/util/utiljpeg"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// resampling kernels
+const (
+	ResampleNearest    = "nearest"
+	ResampleBilinear   = "bilinear"
+	ResampleCatmullRom = "catmullrom"
+	ResampleLanczos3   = "lanczos3"
+)
+
+// lanczos3 windowed sinc, support 3
+var lanczos3 = &draw.Kernel{Support: 3, At: func(t float64) float64 {
+
+	if t == 0 {
+		return 1
+	}
+	if t < 0 {
+		t = -t
+	}
+	if t >= 3 {
+		return 0
+	}
+	x := math.Pi * t
+	return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+}}
+
+// IsResample valid kernel, "" is default (approximate bilinear)
+func IsResample(name string) bool {
+
+	switch name {
+	case "", ResampleNearest, ResampleBilinear, ResampleCatmullRom, ResampleLanczos3:
+		return true
+	}
+	return false
+}
+
+func scaler(name string) draw.Scaler {
+
+	switch name {
+	case ResampleNearest:
+		return draw.NearestNeighbor
+	case ResampleBilinear:
+		return draw.BiLinear
+	case ResampleCatmullRom:
+		return draw.CatmullRom
+	case ResampleLanczos3:
+		return lanczos3
+	}
+	return draw.ApproxBiLinear
+}
+
+// resample scale sr of src into dr of dst with kernel name,
+// large reductions are halved by 2x2 box filter first (no aliasing), nearest is single step
+func resample(dst *image.RGBA, dr image.Rectangle, src image.Image, sr image.Rectangle, name string) {
+
+	if name != ResampleNearest {
+		var cur *image.RGBA
+		for sr.Dx()/2 >= dr.Dx() && sr.Dy()/2 >= dr.Dy() && sr.Dx() > 1 && sr.Dy() > 1 {
+			if cur == nil {
+				// first step from any image type
+				cur = image.NewRGBA(image.Rect(0, 0, sr.Dx(), sr.Dy()))
+				draw.Draw(cur, cur.Rect, src, sr.Min, draw.Src)
+			}
+			cur = halve(cur)
+			src, sr = cur, cur.Rect
+		}
+	}
+
+	scaler(name).Scale(dst, dr, src, sr, draw.Over, nil)
+}
+
+// halve 2x2 box average, odd last row/column dropped
+func halve(src *image.RGBA) *image.RGBA {
+
+	w, h := src.Rect.Dx()/2, src.Rect.Dy()/2
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		r0 := src.Pix[(2*y)*src.Stride:]
+		r1 := src.Pix[(2*y+1)*src.Stride:]
+		d := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			i, j := 8*x, 4*x
+			for c := 0; c < 4; c++ {
+				sum := uint32(r0[i+c]) + uint32(r0[i+4+c]) + uint32(r1[i+c]) + uint32(r1[i+4+c])
+				d[j+c] = uint8((sum + 2) >> 2)
+			}
+		}
+	}
+
+	return dst
+}
+
+// decodeScale JPEG DCT-domain scale of cfg (stored size) for resize t after orientation o,
+// largest where the crop still covers the output; 1 if not t.ScaledDecode or not JPEG
+func decodeScale(cfg image.Config, format string, o int, t *TransformOptions) int {
+
+	if !t.ScaledDecode || format != "jpeg" {
+		return 1
+	}
+
+	width, height := OrientedSize(cfg.Width, cfg.Height, o)
+	fit := FitDims(width, height, t)
+
+	cw, ch := max(1, fit.Crop.Dx()), max(1, fit.Crop.Dy())
+	// full image size where crop is not smaller than output
+	needW := (width*fit.Dst.Dx() + cw - 1) / cw
+	needH := (height*fit.Dst.Dy() + ch - 1) / ch
+	needW, needH = OrientedSize(needW, needH, o) // back to stored
+	return utiljpeg.Scale(cfg.Width, cfg.Height, needW, needH)
+}
+
+// scaleCrop crop of full size width x height into bounds b of image decoded scaled, at 0,0
+func scaleCrop(crop image.Rectangle, b image.Rectangle, width int, height int) image.Rectangle {
+
+	if width < 1 || height < 1 || b.Dx() == width && b.Dy() == height {
+		return crop
+	}
+	return image.Rect(
+		crop.Min.X*b.Dx()/width,
+		crop.Min.Y*b.Dy()/height,
+		min(b.Dx(), (crop.Max.X*b.Dx()+width-1)/width),
+		min(b.Dy(), (crop.Max.Y*b.Dy()+height-1)/height),
+	)
+}
+
+// decodeFor decode data for resize into box t with EXIF orientation applied,
+// JPEG is decoded scaled (t.ScaledDecode) if the result still covers the output;
+// fit is of original size, crop is of the returned image
+func decodeFor(data []byte, t *TransformOptions) (img image.Image, fit FitResult, crop image.Rectangle, err error) {
+
+	o := orientation(data)
+	f, err := decodeFrame(data, o, t)
+	if err != nil {
+		return nil, fit, crop, err
+	}
+	if err := (OrientOp{Orientation: o}).Apply(f); err != nil {
+		return nil, fit, crop, err
+	}
+
+	fit, crop = f.fit(t)
+	return f.Image, fit, crop, nil
+}
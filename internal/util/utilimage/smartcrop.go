@@ -0,0 +1,205 @@
+package utilimage
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// smart crop, in the spirit of smartcrop.js:
+// pixels are scored by edge detail, skin tone and saturation,
+// crop windows slide over the analysis image and are weighted
+// by position (centre and rule of thirds), the best window wins
+
+const (
+	smartAnalysisSize = 128 // px, longer side of analysis image
+
+	smartDetailWeight     = 0.2
+	smartSkinWeight       = 1.8
+	smartSkinBias         = 0.01
+	smartSkinThreshold    = 0.8
+	smartSkinLightMin     = 0.2
+	smartSaturationWeight = 0.1
+	smartSaturationBias   = 0.2
+	smartSaturationThresh = 0.4
+	smartSaturationLight  = 0.05 // min, max is 1-0.1
+	smartEdgeRadius       = 0.4
+	smartEdgeWeight       = -20.0
+	smartOutsideWeight    = -0.5
+)
+
+// smartSkinColor normalized rgb of skin tone
+var smartSkinColor = [3]float64{0.78, 0.57, 0.44}
+
+// smartFeatures per pixel scores of analysis image
+type smartFeatures struct {
+	width, height int
+	detail        []float64
+	skin          []float64
+	saturation    []float64
+}
+
+// CropRect source rect of resize into box t, FitDims crop or SmartCrop for FitCover with GravitySmart
+func CropRect(img image.Image, t *TransformOptions) image.Rectangle {
+
+	b := img.Bounds()
+	fit := FitDims(b.Dx(), b.Dy(), t)
+
+	if t.Fit == FitCover && t.Gravity == GravitySmart {
+		return SmartCrop(img, fit.Crop.Dx(), fit.Crop.Dy())
+	}
+
+	return fit.Crop.Add(b.Min)
+}
+
+// SmartCrop most interesting width x height window of img, in img coords
+func SmartCrop(img image.Image, width int, height int) image.Rectangle {
+
+	b := img.Bounds()
+	width, height = min(max(width, 1), b.Dx()), min(max(height, 1), b.Dy())
+
+	if width == b.Dx() && height == b.Dy() {
+		return b
+	}
+
+	// analysis image, scale <= 1
+	scale := min(1, float64(smartAnalysisSize)/float64(max(b.Dx(), b.Dy())))
+	aw, ah := max(1, int(float64(b.Dx())*scale)), max(1, int(float64(b.Dy())*scale))
+	small := image.NewRGBA(image.Rect(0, 0, aw, ah))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, b, draw.Src, nil)
+
+	f := newSmartFeatures(small)
+
+	// window in analysis coords, slides along the free axis
+	sx, sy := float64(aw)/float64(b.Dx()), float64(ah)/float64(b.Dy())
+	cw, ch := max(1, int(float64(width)*sx)), max(1, int(float64(height)*sy))
+	cw, ch = min(cw, aw), min(ch, ah)
+
+	bestX, bestY, bestScore := 0, 0, math.Inf(-1)
+	for y := 0; y <= ah-ch; y++ {
+		for x := 0; x <= aw-cw; x++ {
+			if s := f.score(x, y, cw, ch); s > bestScore {
+				bestX, bestY, bestScore = x, y, s
+			}
+		}
+	}
+
+	// back to img coords
+	x0 := min(int(math.Round(float64(bestX)/sx)), b.Dx()-width)
+	y0 := min(int(math.Round(float64(bestY)/sy)), b.Dy()-height)
+
+	return image.Rect(x0, y0, x0+width, y0+height).Add(b.Min)
+}
+
+func newSmartFeatures(img *image.RGBA) *smartFeatures {
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	f := &smartFeatures{
+		width:      w,
+		height:     h,
+		detail:     make([]float64, w*h),
+		skin:       make([]float64, w*h),
+		saturation: make([]float64, w*h),
+	}
+
+	light := make([]float64, w*h)
+	rgb := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			o := img.PixOffset(x, y)
+			r, g, b := float64(img.Pix[o])/255, float64(img.Pix[o+1])/255, float64(img.Pix[o+2])/255
+			rgb[y*w+x] = [3]float64{r, g, b}
+			light[y*w+x] = 0.2126*r + 0.7152*g + 0.0722*b
+		}
+	}
+
+	at := func(x int, y int) float64 {
+		return light[min(max(y, 0), h-1)*w+min(max(x, 0), w-1)]
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			l := light[i]
+
+			// edge, laplacian of lightness
+			f.detail[i] = min(1, math.Abs(4*l-at(x-1, y)-at(x+1, y)-at(x, y-1)-at(x, y+1)))
+
+			r, g, b := rgb[i][0], rgb[i][1], rgb[i][2]
+
+			// skin, distance of normalized color to skin tone
+			if l >= smartSkinLightMin {
+				mag := math.Sqrt(r*r + g*g + b*b)
+				if mag > 0 {
+					dr, dg, db := r/mag-smartSkinColor[0], g/mag-smartSkinColor[1], b/mag-smartSkinColor[2]
+					if skin := 1 - math.Sqrt(dr*dr+dg*dg+db*db); skin > smartSkinThreshold {
+						f.skin[i] = (skin - smartSkinThreshold) / (1 - smartSkinThreshold)
+					}
+				}
+			}
+
+			// saturation, hsl
+			if l >= smartSaturationLight && l <= 1-0.1 {
+				maxC, minC := max(r, g, b), min(r, g, b)
+				if d := maxC - minC; d > 0 {
+					sum := maxC + minC
+					sat := d / sum
+					if sum > 1 {
+						sat = d / (2 - sum)
+					}
+					if sat > smartSaturationThresh {
+						f.saturation[i] = (sat - smartSaturationThresh) / (1 - smartSaturationThresh)
+					}
+				}
+			}
+		}
+	}
+
+	return f
+}
+
+// score of crop window, per pixel features weighted by importance
+func (f *smartFeatures) score(cx int, cy int, cw int, ch int) float64 {
+
+	var detail, skin, saturation float64
+	for y := 0; y < f.height; y++ {
+		for x := 0; x < f.width; x++ {
+			i := y*f.width + x
+			imp := smartImportance(cx, cy, cw, ch, x, y)
+			d := f.detail[i]
+			detail += d * imp
+			skin += f.skin[i] * (d + smartSkinBias) * imp
+			saturation += f.saturation[i] * (d + smartSaturationBias) * imp
+		}
+	}
+
+	return (detail*smartDetailWeight + skin*smartSkinWeight + saturation*smartSaturationWeight) / float64(cw*ch)
+}
+
+// smartImportance weight of pixel x,y for crop window, centre and thirds preferred
+func smartImportance(cx int, cy int, cw int, ch int, x int, y int) float64 {
+
+	if x < cx || x >= cx+cw || y < cy || y >= cy+ch {
+		return smartOutsideWeight
+	}
+
+	px := math.Abs(0.5-(float64(x-cx)+0.5)/float64(cw)) * 2
+	py := math.Abs(0.5-(float64(y-cy)+0.5)/float64(ch)) * 2
+
+	dx := max(px-1+smartEdgeRadius, 0)
+	dy := max(py-1+smartEdgeRadius, 0)
+	d := (dx*dx + dy*dy) * smartEdgeWeight
+
+	s := 1.41 - math.Sqrt(px*px+py*py)
+	s += max(0, s+d+0.5) * 1.2 * (smartThirds(px) + smartThirds(py))
+
+	return s + d
+}
+
+// smartThirds peak at 1/3 from centre
+func smartThirds(x float64) float64 {
+
+	x = (math.Mod(x-1.0/3+1, 2)*0.5 - 0.5) * 16
+	return max(1-x*x, 0)
+}
@@ -5,19 +5,118 @@ import (
 	"bytes"
 	_ "embed"
 	"fmt"
+	"go-image/internal/util/utilexif"
 	"go-image/internal/util/utilfont"
+	"go-image/internal/util/utilicc"
+	"go-image/internal/util/utilwebp"
 	"image"
 	"image/color"
+	"image/color/palette"
+	"image/gif"
 	"image/jpeg"
-	"math"
+	"image/png"
+	"strconv"
+	"strings"
 	"sync"
 
+	_ "golang.org/x/image/bmp" // decoder
 	"golang.org/x/image/draw"
-	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
-	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/tiff" // decoder
+	_ "golang.org/x/image/webp" // decoder
 )
 
+// output formats
+const (
+	FormatJPEG = "jpeg"
+	FormatWEBP = "webp"
+	FormatPNG  = "png"
+	FormatGIF  = "gif" // first frame, see TransformAnimated
+)
+
+// DefaultBackground fill of transparent pixels for formats without alpha
+var DefaultBackground = color.RGBA{255, 255, 255, 255}
+
+// fit modes
+const (
+	FitContain = "contain" // inside box, aspect kept
+	FitCover   = "cover"   // fill box, aspect kept, crop by gravity
+	FitFill    = "fill"    // fill box, aspect ignored
+	FitPad     = "pad"     // box size, contain placed by gravity on Background
+)
+
+// gravity, anchor of FitCover crop and FitPad placement
+const (
+	GravityCenter    = "center"
+	GravityNorth     = "north"
+	GravitySouth     = "south"
+	GravityEast      = "east"
+	GravityWest      = "west"
+	GravityNorthEast = "northeast"
+	GravityNorthWest = "northwest"
+	GravitySouthEast = "southeast"
+	GravitySouthWest = "southwest"
+	GravitySmart     = "smart" // FitCover content aware, see SmartCrop; center in FitDims
+)
+
+// gravities anchor x, y: 0 start, 1 center, 2 end
+var gravities = map[string][2]int{
+	GravityCenter:    {1, 1},
+	GravityNorth:     {1, 0},
+	GravitySouth:     {1, 2},
+	GravityEast:      {2, 1},
+	GravityWest:      {0, 1},
+	GravityNorthEast: {2, 0},
+	GravityNorthWest: {0, 0},
+	GravitySouthEast: {2, 2},
+	GravitySouthWest: {0, 2},
+}
+
+// IsFit valid fit mode
+func IsFit(fit string) bool {
+	switch fit {
+	case FitContain, FitCover, FitFill, FitPad:
+		return true
+	}
+	return false
+}
+
+// IsGravity valid gravity
+func IsGravity(gravity string) bool {
+	_, ok := gravities[gravity]
+	return ok || gravity == GravitySmart
+}
+
+// TransformOptions box and fit, zero Width or Height is not limited (contain)
+type TransformOptions struct {
+	Width      int
+	Height     int
+	Fit        string      // FitContain (default), FitCover, FitFill, FitPad
+	Gravity    string      // GravityCenter (default)
+	Background color.Color // FitPad fill, nil is transparent
+
+	Resample     string // ResampleLanczos3 ..., "" approximate bilinear
+	ScaledDecode bool   // JPEG DCT-domain scaling on decode, see utiljpeg
+	ConvertSRGB  bool   // colours of embedded ICC profile to sRGB, see utilicc
+}
+
+// FitResult geometry of resize into TransformOptions box
+type FitResult struct {
+	Width  int             // output
+	Height int             // output
+	Crop   image.Rectangle // source rect
+	Dst    image.Rectangle // output rect of scaled source, inside output for FitPad
+}
+
+// EncodeOptions output encoding
+type EncodeOptions struct {
+	Format     string      // FormatJPEG (default), FormatWEBP, FormatPNG, FormatGIF
+	Quality    int         // FormatJPEG, FormatWEBP
+	Background color.Color // nil is DefaultBackground, FormatPNG keeps alpha, FormatGIF a transparent index
+	Metadata   string      // of source: MetadataStrip (default), MetadataCopyright, MetadataKeep
+	EmbedSRGB  bool        // sRGB ICC profile in output
+}
+
 var mu sync.Mutex
 var cachedFontWatermark *opentype.Font
 
@@ -45,144 +144,277 @@ var cachedFontWatermark *opentype.Font
 //		return outBuffer.Bytes(), nil
 //	}
 
-func Resize(data []byte, newSize int, quality int) ([]byte, error) {
-	// Decode the image from byte data
-	imgOld, _, err := image.Decode(bytes.NewBuffer(data))
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %v", err)
-	}
+// encode capHint is initial buffer cap, meta is embedded if not nil
+func encode(img image.Image, enc *EncodeOptions, meta *utilexif.Metadata, capHint int) ([]byte, error) {
 
-	// Determine the aspect ratio to maintain proportional resizing
-	originalBounds := imgOld.Bounds()
-	width := originalBounds.Dx()
-	height := originalBounds.Dy()
-
-	var newWidth, newHeight int
-	if width > height {
-		newWidth = newSize
-		newHeight = height * newSize / width
-	} else {
-		newHeight = newSize
-		newWidth = width * newSize / height
+	if enc.EmbedSRGB {
+		m := &utilexif.Metadata{ICC: utilicc.SRGB()}
+		if meta != nil {
+			m.Exif, m.XMP = meta.Exif, meta.XMP
+		}
+		meta = m
 	}
 
-	// Create a new empty image with the new dimensions
-	newImg := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	outBuffer := bytes.NewBuffer(make([]byte, 0, capHint)) // with cap
 
-	// BiLinear
-	draw.ApproxBiLinear.Scale(newImg, newImg.Bounds(), imgOld, originalBounds, draw.Over, nil)
+	var err error
+	switch enc.Format {
+	case FormatJPEG, "":
+		err = jpeg.Encode(outBuffer, flatten(img, enc.Background), &jpeg.Options{Quality: enc.Quality})
+	case FormatWEBP:
+		opt := &utilwebp.Options{Quality: enc.Quality}
+		if meta != nil {
+			opt.Exif, opt.XMP, opt.ICC = meta.Exif, meta.XMP, meta.ICC
+		}
+		err = utilwebp.Encode(outBuffer, flatten(img, enc.Background), opt)
+	case FormatPNG:
+		err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(outBuffer, img)
+	case FormatGIF:
+		err = gif.Encode(outBuffer, gifImage(img, enc.Background), nil)
+	default:
+		return nil, fmt.Errorf("unsupported image format: %v", enc.Format)
+	}
 
-	outBuffer := bytes.NewBuffer(make([]byte, 0, len(data))) // with cap
-	err = jpeg.Encode(outBuffer, newImg, &jpeg.Options{Quality: quality})
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode image: %v", err)
 	}
 
+	if meta != nil {
+		switch enc.Format {
+		case FormatJPEG, "":
+			return embedJPEG(outBuffer.Bytes(), meta), nil
+		case FormatPNG:
+			return embedPNG(outBuffer.Bytes(), meta), nil
+		}
+	}
+
 	return outBuffer.Bytes(), nil
 }
 
-func Size(data []byte) ([]int, error) {
+// flatten draws non-opaque img over background
+func flatten(img image.Image, background color.Color) image.Image {
 
-	img, _, err := image.Decode(bytes.NewBuffer(data))
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %v", err)
+	if background == nil {
+		background = DefaultBackground
 	}
 
-	bounds := img.Bounds()
-	width := bounds.Dx()  // Width
-	height := bounds.Dy() // Height
+	res := image.NewRGBA(img.Bounds())
+	draw.Draw(res, res.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(res, res.Bounds(), img, img.Bounds().Min, draw.Over)
 
-	return []int{width, height}, nil
+	return res
 }
 
-// Watermark ImageWatermarkSizeGreaterThan > 400;
-func Watermark(data []byte, text string, quality int) ([]byte, error) {
+// gifImage of img for gif.Encode: pixels under half alpha get a transparent palette index,
+// others are flattened on background and dithered to palette.Plan9 (as by gif.Encode)
+func gifImage(img image.Image, background color.Color) image.Image {
 
-	if text == "" {
-		return data, nil
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
 	}
 
-	// Decode the image from byte data
-	imgOld, _, err := image.Decode(bytes.NewBuffer(data))
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %v", err)
+	src := toRGBA(img)
+	flat := toRGBA(flatten(src, background))
+
+	transparent := false
+	for i := 3; i < len(src.Pix); i += 4 {
+		if src.Pix[i] < 128 {
+			copy(flat.Pix[i-3:i+1], []uint8{0, 0, 0, 0})
+			transparent = true
+		}
+	}
+	if !transparent {
+		return flat
+	}
+
+	pal := append(color.Palette{}, palette.Plan9[:255]...)
+	pal = append(pal, color.Transparent)
+
+	res := image.NewPaletted(flat.Rect, pal)
+	draw.FloydSteinberg.Draw(res, res.Rect, flat, image.Point{})
+	return res
+}
+
+// ParseColor parse "#rgb", "#rrggbb" or "#rrggbbaa" (# is optional)
+func ParseColor(s string) (color.RGBA, error) {
+
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("error color not valid: %v", s)
 	}
 
-	imgNew, err := addWatermarkCenter(imgOld, text)
+	// color.RGBA is alpha-premultiplied
+	c := color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}
+	return color.RGBAModel.Convert(c).(color.RGBA), nil
+}
+
+// Validate full decode, detects truncated or corrupted data
+func Validate(data []byte) error {
+
+	_, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return nil, fmt.Errorf("failed to add wm to image: %v", err)
+		return fmt.Errorf("failed to decode image: %v", err)
 	}
 
-	outBuffer := bytes.NewBuffer(make([]byte, 0, len(data))) // with cap
+	return nil
+}
+
+// Size width, height from header (no pixel decoding)
+func Size(data []byte) ([]int, error) {
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
 
-	err = jpeg.Encode(outBuffer, imgNew, &jpeg.Options{Quality: quality})
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode image: %v", err)
+		return nil, fmt.Errorf("failed to decode image: %v", err)
 	}
 
-	data = outBuffer.Bytes()
+	return []int{cfg.Width, cfg.Height}, nil
+}
+
+// DecodeMemory estimated peak bytes of Pipeline of data with header cfg and format:
+// decoded pixels and one RGBA working copy (orientation, conversion);
+// animated GIF paletted frames and RGBA canvases, see TransformAnimated
+func DecodeMemory(data []byte, cfg image.Config, format string) int64 {
+
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+
+	if format == "gif" {
+		if frames, _, _, err := GIFFrames(data); err == nil && frames > 1 {
+			return int64(frames)*pixels + 2*4*pixels
+		}
+	}
 
-	return data, nil
+	bpp := int64(4)
+	switch cfg.ColorModel {
+	case color.GrayModel, color.AlphaModel:
+		bpp = 1
+	case color.Gray16Model, color.Alpha16Model:
+		bpp = 2
+	case color.YCbCrModel:
+		bpp = 3 // 4:4:4 worst case
+	case color.RGBA64Model, color.NRGBA64Model:
+		bpp = 8
+	default:
+		if _, ok := cfg.ColorModel.(color.Palette); ok {
+			bpp = 1
+		}
+	}
+
+	return pixels * (bpp + 4)
 }
 
-func addWatermarkCenter(imgOld image.Image, text string) (image.Image, error) {
+// FitDims geometry of resize into box t
+func FitDims(width int, height int, t *TransformOptions) (res FitResult) {
 
-	// Parse the TTF font from byte slice (utilfont.FontRobotoBoldTtf)
-	fnt, err := getFontWatermark()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get font: %v", err)
+	res.Crop = image.Rect(0, 0, width, height)
+	boxW, boxH := t.Width, t.Height
+
+	gravity, ok := gravities[t.Gravity]
+	if !ok {
+		gravity = gravities[GravityCenter]
 	}
-	heightOld := imgOld.Bounds().Dy()
-	fontSize := math.Min(36, math.Max(24, float64(heightOld/10)))
-	// Create a font.Face with size 24 and DPI 72
-	face, err := opentype.NewFace(fnt, &opentype.FaceOptions{
-		Size:    fontSize,
-		DPI:     72,
-		Hinting: font.HintingFull,
-	})
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to create font face: %v", err)
+	// offset of free space by gravity
+	offset := func(free int, anchor int) int {
+		return free * anchor / 2
 	}
 
-	defer face.Close()
+	result := func(w int, h int) FitResult {
+		res.Width, res.Height = w, h
+		res.Dst = image.Rect(0, 0, w, h)
+		return res
+	}
 
-	// Create a new RGBA image to draw the watermark on
-	imgNew := image.NewRGBA(imgOld.Bounds())
-	draw.Draw(imgNew, imgNew.Bounds(), imgOld, image.Point{}, draw.Src)
+	switch {
+	case width < 1 || height < 1:
+		return result(1, 1)
+	case boxW < 1 && boxH < 1:
+		return result(width, height)
+	case boxW < 1:
+		return result(max(1, width*boxH/height), boxH)
+	case boxH < 1:
+		return result(boxW, max(1, height*boxW/width))
+	}
 
-	// Measure the width of the text string using the font's metrics
-	d := &font.Drawer{
-		Face: face,
+	// contain size
+	containW, containH := boxW, boxH
+	if width*boxH > height*boxW {
+		containH = max(1, height*boxW/width)
+	} else {
+		containW = max(1, width*boxH/height)
 	}
-	textWidth := d.MeasureString(text).Round()
 
-	boundsNew := imgNew.Bounds()
-	widthNew := boundsNew.Dx()  // Width
-	heightNew := boundsNew.Dy() // Height
+	switch t.Fit {
+	case FitFill:
+		return result(boxW, boxH)
+	case FitCover:
+		// crop source to box aspect
+		if width*boxH > height*boxW {
+			cropW := max(1, height*boxW/boxH)
+			x0 := offset(width-cropW, gravity[0])
+			res.Crop = image.Rect(x0, 0, x0+cropW, height)
+		} else {
+			cropH := max(1, width*boxH/boxW)
+			y0 := offset(height-cropH, gravity[1])
+			res.Crop = image.Rect(0, y0, width, y0+cropH)
+		}
+		return result(boxW, boxH)
+	case FitPad:
+		res = result(boxW, boxH)
+		x0 := offset(boxW-containW, gravity[0])
+		y0 := offset(boxH-containH, gravity[1])
+		res.Dst = image.Rect(x0, y0, x0+containW, y0+containH)
+		return res
+	}
 
-	// Calculate the position to center the text
-	x := (widthNew - textWidth) / 2
-	y := heightNew / 2
+	return result(containW, containH)
+}
 
-	// Set the text color with transparency (alpha 128 for 50% opacity)
-	col := color.RGBA{65, 65, 65, 65}
+// ColorModelName of DecodeConfig color model: "rgba" "ycbcr" "gray" "paletted" ...
+func ColorModelName(m color.Model) string {
 
-	// Draw the text at the calculated center point
-	d = &font.Drawer{
-		Dst:  imgNew,
-		Src:  image.NewUniform(col),
-		Face: face,
-		Dot: fixed.Point26_6{
-			X: fixed.I(x),
-			Y: fixed.I(y),
-		},
+	if _, ok := m.(color.Palette); ok {
+		return "paletted"
 	}
 
-	d.DrawString(text)
+	switch m {
+	case color.RGBAModel:
+		return "rgba"
+	case color.RGBA64Model:
+		return "rgba64"
+	case color.NRGBAModel:
+		return "nrgba"
+	case color.NRGBA64Model:
+		return "nrgba64"
+	case color.AlphaModel:
+		return "alpha"
+	case color.Alpha16Model:
+		return "alpha16"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.NYCbCrAModel:
+		return "nycbcra"
+	case color.CMYKModel:
+		return "cmyk"
+	}
 
-	return imgNew, nil
+	return "unknown"
 }
 
 // getFontWatermark loads the font if not already cached
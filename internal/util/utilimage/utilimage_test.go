@@ -1,14 +1,25 @@
 package utilimage
 
 import (
+	"bytes"
 	_ "embed"
+	"encoding/binary"
 	"fmt"
+	"go-image/internal/util/utilexif"
 	"go-image/internal/util/utilfile"
+	"go-image/internal/util/utilicc"
 	"go-image/internal/util/utiltest"
+	"image"
+	"image/color"
+	"image/gif"
 	_ "image/jpeg"
+	"image/png"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
+
+	"golang.org/x/image/draw"
 )
 
 func getWorkDir() string {
@@ -23,7 +34,7 @@ func BenchmarkResize(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 
-		_, err := Resize(imgTest, 400, 80)
+		_, err := resize(imgTest, 400, 80)
 
 		if err != nil {
 			b.Fatal(err)
@@ -36,7 +47,7 @@ func BenchmarkWatermark(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 
-		_, err := Watermark(imgTest, "EXAMPLE.COM", 75)
+		_, err := watermark(imgTest, "EXAMPLE.COM", 75)
 
 		if err != nil {
 			b.Fatal(err)
@@ -50,13 +61,13 @@ func BenchmarkResizeWatermark(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 
-		imgR, err := Resize(imgTest, 400, 75)
+		imgR, err := resize(imgTest, 400, 75)
 
 		if err != nil {
 			b.Fatal(err)
 		}
 
-		imgWM, err := Watermark(imgR, "EXAMPLE.COM", 75)
+		imgWM, err := watermark(imgR, "EXAMPLE.COM", 75)
 
 		if err != nil {
 			b.Fatal(err)
@@ -70,11 +81,11 @@ func TestAddWatermark(t *testing.T) {
 	var imgTest = utiltest.GetTestImage()
 
 	for _, v := range []int{400, 600} {
-		data, err := Resize(imgTest, v, 75)
+		data, err := resize(imgTest, v, 75)
 		if err != nil {
 			t.Fatal(err)
 		}
-		data, err = Watermark(data, "EXAMPLE.COM", 75)
+		data, err = watermark(data, "EXAMPLE.COM", 75)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -87,3 +98,950 @@ func TestAddWatermark(t *testing.T) {
 	}
 
 }
+
+func TestPipelineWatermarkWebp(t *testing.T) {
+	wd := getWorkDir()
+	var imgTest = utiltest.GetTestImage()
+
+	p := &Pipeline{Ops: append(
+		TransformOps(imgTest, &TransformOptions{Width: 400, Height: 400, Fit: FitContain}),
+		WatermarkOp{Watermark: &WatermarkOptions{Text: "EXAMPLE.COM"}},
+	)}
+	data, err := p.Run(imgTest, &EncodeOptions{Format: FormatWEBP, Quality: 75})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := Size(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size[0] != 400 {
+		t.Fatalf("width %v, want 400", size[0])
+	}
+
+	utilfile.FileWriteWithDir(wd+"/wm-400.webp", data)
+}
+
+// transform resize into box t and encode, one pipeline
+func transform(data []byte, t *TransformOptions, enc *EncodeOptions) ([]byte, error) {
+
+	p := &Pipeline{Ops: TransformOps(data, t)}
+	return p.Run(data, enc)
+}
+
+// resize jpeg into newSize x newSize
+func resize(data []byte, newSize int, quality int) ([]byte, error) {
+
+	return transform(data, &TransformOptions{Width: newSize, Height: newSize, Fit: FitContain}, &EncodeOptions{Format: FormatJPEG, Quality: quality})
+}
+
+// watermark jpeg with text, oriented
+func watermark(data []byte, text string, quality int) ([]byte, error) {
+
+	p := &Pipeline{Ops: []Op{OrientOp{Orientation: orientation(data)}, WatermarkOp{Watermark: &WatermarkOptions{Text: text}}}}
+	return p.Run(data, &EncodeOptions{Format: FormatJPEG, Quality: quality})
+}
+
+// transparentPNG left half transparent, right half opaque red
+func transparentPNG(t *testing.T) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 100; x < 200; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPipelinePNGAlpha(t *testing.T) {
+
+	src := transparentPNG(t)
+
+	data, err := transform(src, &TransformOptions{Width: 100, Height: 100, Fit: FitContain}, &EncodeOptions{Format: FormatPNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" {
+		t.Fatalf("format %v, want png", format)
+	}
+
+	if _, _, _, a := img.At(10, 10).RGBA(); a != 0 {
+		t.Errorf("alpha %v, want transparent", a)
+	}
+	if r, _, _, a := img.At(90, 10).RGBA(); a != 0xffff || r != 0xffff {
+		t.Errorf("r %v alpha %v, want opaque red", r, a)
+	}
+}
+
+func TestPipelineGIFAlpha(t *testing.T) {
+
+	src := transparentPNG(t)
+
+	data, err := transform(src, &TransformOptions{Width: 100, Height: 100, Fit: FitContain}, &EncodeOptions{Format: FormatGIF})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := gif.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, a := img.At(10, 10).RGBA(); a != 0 {
+		t.Errorf("alpha %v, want transparent", a)
+	}
+	if r, g, _, a := img.At(90, 10).RGBA(); a != 0xffff || r < 0xf000 || g > 0x1000 {
+		t.Errorf("r %v g %v alpha %v, want opaque red", r, g, a)
+	}
+}
+
+func TestPipelineJPEGBackground(t *testing.T) {
+
+	src := transparentPNG(t)
+
+	for _, tt := range []struct {
+		name       string
+		background color.Color
+		want       color.RGBA
+	}{
+		{"default", nil, DefaultBackground},
+		{"black", color.RGBA{0, 0, 0, 255}, color.RGBA{0, 0, 0, 255}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := transform(src, &TransformOptions{Width: 100, Height: 100, Fit: FitContain}, &EncodeOptions{Format: FormatJPEG, Quality: 90, Background: tt.background})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, g, b, _ := img.At(10, 10).RGBA()
+			got := []int{int(r >> 8), int(g >> 8), int(b >> 8)}
+			want := []int{int(tt.want.R), int(tt.want.G), int(tt.want.B)}
+			for i := range got {
+				if d := got[i] - want[i]; d < -4 || d > 4 {
+					t.Fatalf("color %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseColor(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want color.RGBA
+		err  bool
+	}{
+		{"#ffffff", color.RGBA{255, 255, 255, 255}, false},
+		{"000", color.RGBA{0, 0, 0, 255}, false},
+		{"#f80", color.RGBA{255, 136, 0, 255}, false},
+		{"#ff000080", color.RGBA{128, 0, 0, 128}, false},
+		{"", color.RGBA{}, true},
+		{"#ggg", color.RGBA{}, true},
+		{"#12345", color.RGBA{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseColor(tt.in)
+		if (err != nil) != tt.err {
+			t.Fatalf("%q err %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	src := utiltest.GetTestImage()
+
+	for _, enc := range []*EncodeOptions{
+		{Format: FormatJPEG, Quality: 75},
+		{Format: FormatWEBP, Quality: 75},
+		{Format: FormatPNG},
+	} {
+		t.Run(enc.Format, func(t *testing.T) {
+			data, err := transform(src, &TransformOptions{Width: 200, Height: 200, Fit: FitContain}, enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Validate(data); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Validate(data[:len(data)/2]); err == nil {
+				t.Fatal("truncated image is valid")
+			}
+		})
+	}
+}
+
+func TestColorModelName(t *testing.T) {
+
+	paletted := &bytes.Buffer{}
+	if err := png.Encode(paletted, image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"jpeg", utiltest.GetTestImage(), "ycbcr"},
+		{"png paletted", paletted.Bytes(), "paletted"},
+		{"png nrgba", transparentPNG(t), "nrgba"},
+	} {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ColorModelName(cfg.ColorModel); got != tt.want {
+			t.Errorf("%v got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFitDims(t *testing.T) {
+
+	r := image.Rect
+	tests := []struct {
+		name          string
+		width, height int
+		opt           TransformOptions
+		want          FitResult
+	}{
+		{"contain wide", 400, 200, TransformOptions{Width: 100, Height: 100, Fit: FitContain}, FitResult{100, 50, r(0, 0, 400, 200), r(0, 0, 100, 50)}},
+		{"contain tall", 200, 400, TransformOptions{Width: 100, Height: 100}, FitResult{50, 100, r(0, 0, 200, 400), r(0, 0, 50, 100)}},
+		{"contain box", 400, 200, TransformOptions{Width: 300, Height: 50, Fit: FitContain}, FitResult{100, 50, r(0, 0, 400, 200), r(0, 0, 100, 50)}},
+		{"width only", 400, 200, TransformOptions{Width: 100, Fit: FitCover}, FitResult{100, 50, r(0, 0, 400, 200), r(0, 0, 100, 50)}},
+		{"height only", 400, 200, TransformOptions{Height: 100, Fit: FitPad}, FitResult{200, 100, r(0, 0, 400, 200), r(0, 0, 200, 100)}},
+		{"cover wide", 400, 200, TransformOptions{Width: 100, Height: 100, Fit: FitCover}, FitResult{100, 100, r(100, 0, 300, 200), r(0, 0, 100, 100)}},
+		{"cover tall", 200, 400, TransformOptions{Width: 100, Height: 50, Fit: FitCover}, FitResult{100, 50, r(0, 150, 200, 250), r(0, 0, 100, 50)}},
+		{"cover west", 400, 200, TransformOptions{Width: 100, Height: 100, Fit: FitCover, Gravity: GravityWest}, FitResult{100, 100, r(0, 0, 200, 200), r(0, 0, 100, 100)}},
+		{"cover southeast", 200, 400, TransformOptions{Width: 100, Height: 50, Fit: FitCover, Gravity: GravitySouthEast}, FitResult{100, 50, r(0, 300, 200, 400), r(0, 0, 100, 50)}},
+		{"cover north", 200, 400, TransformOptions{Width: 100, Height: 50, Fit: FitCover, Gravity: GravityNorth}, FitResult{100, 50, r(0, 0, 200, 100), r(0, 0, 100, 50)}},
+		{"fill", 400, 200, TransformOptions{Width: 100, Height: 100, Fit: FitFill}, FitResult{100, 100, r(0, 0, 400, 200), r(0, 0, 100, 100)}},
+		{"pad center", 400, 200, TransformOptions{Width: 100, Height: 100, Fit: FitPad}, FitResult{100, 100, r(0, 0, 400, 200), r(0, 25, 100, 75)}},
+		{"pad south", 400, 200, TransformOptions{Width: 100, Height: 100, Fit: FitPad, Gravity: GravitySouth}, FitResult{100, 100, r(0, 0, 400, 200), r(0, 50, 100, 100)}},
+		{"pad east", 200, 400, TransformOptions{Width: 100, Height: 100, Fit: FitPad, Gravity: GravityEast}, FitResult{100, 100, r(0, 0, 200, 400), r(50, 0, 100, 100)}},
+		{"min 1px", 1000, 1, TransformOptions{Width: 100, Height: 100, Fit: FitContain}, FitResult{100, 1, r(0, 0, 1000, 1), r(0, 0, 100, 1)}},
+	}
+
+	for _, tt := range tests {
+		if got := FitDims(tt.width, tt.height, &tt.opt); got != tt.want {
+			t.Errorf("%v got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTransformPad(t *testing.T) {
+
+	red := color.RGBA{255, 0, 0, 255}
+	data, err := transform(utiltest.GetTestImage(), &TransformOptions{Width: 300, Height: 100, Fit: FitPad, Background: red}, &EncodeOptions{Format: FormatPNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 100 {
+		t.Fatalf("size %v, want 300x100", b)
+	}
+	if r, g, b, _ := img.At(2, 50).RGBA(); r>>8 != 255 || g>>8 != 0 || b>>8 != 0 {
+		t.Fatalf("pad color %v %v %v, want red", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestTransformCover(t *testing.T) {
+
+	data, err := transform(utiltest.GetTestImage(), &TransformOptions{Width: 120, Height: 120, Fit: FitCover}, &EncodeOptions{Format: FormatJPEG, Quality: 75})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := Size(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size[0] != 120 || size[1] != 120 {
+		t.Fatalf("size %v, want 120x120", size)
+	}
+}
+
+func TestSmartCrop(t *testing.T) {
+
+	// flat grey, detailed saturated stripes at x 220..280
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 300; x++ {
+			c := color.RGBA{128, 128, 128, 255}
+			if x >= 220 && x < 280 && y >= 20 && y < 80 && (x/3+y/3)%2 == 0 {
+				c = color.RGBA{230, 40, 40, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	got := SmartCrop(img, 100, 100)
+	if got.Dx() != 100 || got.Dy() != 100 || !got.In(img.Bounds()) {
+		t.Fatalf("crop %v not 100x100 inside image", got)
+	}
+	if got.Min.X < 180 {
+		t.Fatalf("crop %v misses the subject at x 220..280", got)
+	}
+
+	// FitDims uses center for smart, CropRect moves it
+	opt := &TransformOptions{Width: 50, Height: 50, Fit: FitCover, Gravity: GravitySmart}
+	if fit := FitDims(300, 100, opt); fit.Crop != image.Rect(100, 0, 200, 100) {
+		t.Fatalf("FitDims crop %v, want center", fit.Crop)
+	}
+	if r := CropRect(img, opt); r != got {
+		t.Fatalf("CropRect %v, want %v", r, got)
+	}
+}
+
+func TestResample(t *testing.T) {
+
+	// 1px checkerboard, aliases to black or white without prefilter
+	src := image.NewGray(image.Rect(0, 0, 800, 800))
+	for y := 0; y < 800; y++ {
+		for x := 0; x < 800; x++ {
+			if (x+y)%2 == 0 {
+				src.Pix[y*src.Stride+x] = 255
+			}
+		}
+	}
+	b := &bytes.Buffer{}
+	if err := png.Encode(b, src); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", ResampleBilinear, ResampleCatmullRom, ResampleLanczos3} {
+		data, err := transform(b.Bytes(), &TransformOptions{Width: 100, Height: 100, Resample: name}, &EncodeOptions{Format: FormatPNG})
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != 100 || img.Bounds().Dy() != 100 {
+			t.Fatalf("%q size %v", name, img.Bounds())
+		}
+		for _, p := range []image.Point{{10, 10}, {50, 51}, {99, 0}} {
+			if g := color.GrayModel.Convert(img.At(p.X, p.Y)).(color.Gray).Y; g < 110 || g > 145 {
+				t.Fatalf("%q at %v gray %v, want ~128", name, p, g)
+			}
+		}
+	}
+
+	if !IsResample("") || !IsResample(ResampleLanczos3) || IsResample("bicubic") {
+		t.Fatal("IsResample")
+	}
+}
+
+func TestTransformScaledDecode(t *testing.T) {
+
+	opts := []TransformOptions{
+		{Width: 100, Height: 100},
+		{Width: 120, Height: 60, Fit: FitCover},
+		{Width: 200, Height: 100, Fit: FitPad},
+	}
+
+	for _, opt := range opts {
+		full, err := transform(utiltest.GetTestImage(), &opt, &EncodeOptions{Format: FormatPNG})
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt.ScaledDecode = true
+		scaled, err := transform(utiltest.GetTestImage(), &opt, &EncodeOptions{Format: FormatPNG})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, _ := png.Decode(bytes.NewReader(full))
+		b, _ := png.Decode(bytes.NewReader(scaled))
+		if a.Bounds() != b.Bounds() {
+			t.Fatalf("%+v size %v, want %v", opt, b.Bounds(), a.Bounds())
+		}
+
+		var diff int
+		for y := 0; y < a.Bounds().Dy(); y++ {
+			for x := 0; x < a.Bounds().Dx(); x++ {
+				ga := int(color.GrayModel.Convert(a.At(x, y)).(color.Gray).Y)
+				gb := int(color.GrayModel.Convert(b.At(x, y)).(color.Gray).Y)
+				diff += max(ga-gb, gb-ga)
+			}
+		}
+		if mean := diff / (a.Bounds().Dx() * a.Bounds().Dy()); mean > 8 {
+			t.Fatalf("%+v mean diff %v", opt, mean)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+
+	// 3x2, distinct pixels
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Pix[4*i] = uint8(i + 1)
+	}
+	px := func(img image.Image, x, y int) uint8 {
+		return img.(*image.RGBA).Pix[img.(*image.RGBA).PixOffset(x, y)]
+	}
+	at := func(x, y int) uint8 { return uint8(y*3 + x + 1) }
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		topLeft     uint8 // src pixel at dst 0,0
+		topRight    uint8
+	}{
+		{1, 3, 2, at(0, 0), at(2, 0)},
+		{2, 3, 2, at(2, 0), at(0, 0)},
+		{3, 3, 2, at(2, 1), at(0, 1)},
+		{4, 3, 2, at(0, 1), at(2, 1)},
+		{5, 2, 3, at(0, 0), at(0, 1)},
+		{6, 2, 3, at(0, 1), at(0, 0)},
+		{7, 2, 3, at(2, 1), at(2, 0)},
+		{8, 2, 3, at(2, 0), at(2, 1)},
+	}
+	for _, tt := range tests {
+		img := Orient(src, tt.orientation)
+		b := img.Bounds()
+		if b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Fatalf("orientation %v size %v", tt.orientation, b)
+		}
+		if px(img, 0, 0) != tt.topLeft || px(img, tt.w-1, 0) != tt.topRight {
+			t.Fatalf("orientation %v corners %v %v, want %v %v", tt.orientation, px(img, 0, 0), px(img, tt.w-1, 0), tt.topLeft, tt.topRight)
+		}
+	}
+}
+
+// tiffASCII little endian TIFF, IFD0 Orientation and ASCII tags
+func tiffASCII(orientation uint16, tags map[uint16]string) []byte {
+
+	keys := []uint16{}
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	n := 1 + len(keys)
+	values := 8 + 2 + 12*n + 4
+	b := &bytes.Buffer{}
+	data := []byte{}
+	b.WriteString("II*\x00\x08\x00\x00\x00")
+	_ = binary.Write(b, binary.LittleEndian, uint16(n))
+	_ = binary.Write(b, binary.LittleEndian, []uint16{0x0112, 3, 1, 0, orientation, 0})
+	for _, k := range keys {
+		v := tags[k] + "\x00"
+		_ = binary.Write(b, binary.LittleEndian, []uint16{k, 2})
+		_ = binary.Write(b, binary.LittleEndian, []uint32{uint32(len(v)), uint32(values + len(data))})
+		data = append(data, v...)
+	}
+	_ = binary.Write(b, binary.LittleEndian, uint32(0))
+	b.Write(data)
+	return b.Bytes()
+}
+
+// withMetadata test image with APP1 EXIF and XMP
+func withMetadata(tiff []byte, xmp string) []byte {
+
+	jpg := utiltest.GetTestImage()
+	res := append([]byte{}, jpg[:2]...)
+	for _, payload := range [][]byte{append([]byte("Exif\x00\x00"), tiff...), append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)} {
+		res = append(res, 0xff, 0xe1)
+		res = binary.BigEndian.AppendUint16(res, uint16(len(payload)+2))
+		res = append(res, payload...)
+	}
+	return append(res, jpg[2:]...)
+}
+
+func TestTransformOrientation(t *testing.T) {
+
+	data := withMetadata(tiffASCII(6, nil), "")
+
+	for _, scaled := range []bool{false, true} {
+		out, err := transform(data, &TransformOptions{Width: 200, Height: 200, ScaledDecode: scaled}, &EncodeOptions{Format: FormatPNG})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 949x770 rotated
+		if cfg.Width != 162 || cfg.Height != 200 {
+			t.Fatalf("scaled %v size %vx%v, want 162x200", scaled, cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestTransformMetadata(t *testing.T) {
+
+	tags := map[uint16]string{0x010f: "Phone Inc", utilexif.TagArtist: "Jane", utilexif.TagCopyright: "(c) Jane"}
+	data := withMetadata(tiffASCII(6, tags), `<x:xmpmeta><rdf:Description tiff:Orientation="6"/></x:xmpmeta>`)
+
+	for _, format := range []string{FormatJPEG, FormatPNG, FormatWEBP} {
+		for _, policy := range []string{MetadataStrip, MetadataCopyright, MetadataKeep} {
+			out, err := transform(data, &TransformOptions{Width: 100, Height: 100}, &EncodeOptions{Format: format, Quality: 80, Metadata: policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("%v %v decode: %v", format, policy, err)
+			}
+
+			m, err := utilexif.Read(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("%v %v read: %v", format, policy, err)
+			}
+			o, _ := utilexif.Orientation(bytes.NewReader(out))
+
+			hasMake := bytes.Contains(m.Exif, []byte("Phone Inc"))
+			hasCopyright := bytes.Contains(m.Exif, []byte("(c) Jane")) && bytes.Contains(m.Exif, []byte("Jane\x00"))
+
+			switch policy {
+			case MetadataStrip:
+				if !m.IsEmpty() {
+					t.Fatalf("%v %v metadata not stripped", format, policy)
+				}
+			case MetadataCopyright:
+				if !hasCopyright || hasMake || len(m.XMP) > 0 {
+					t.Fatalf("%v %v exif %q xmp %q", format, policy, m.Exif, m.XMP)
+				}
+			case MetadataKeep:
+				if !hasCopyright || !hasMake || o != 1 || !bytes.Contains(m.XMP, []byte(`tiff:Orientation="1"`)) {
+					t.Fatalf("%v %v orientation %v exif %q xmp %q", format, policy, o, m.Exif, m.XMP)
+				}
+			}
+		}
+	}
+}
+
+// linearProfile sRGB primaries with linear transfer, padded to span APP2 chunks
+func linearProfile() []byte {
+
+	icc := append([]byte{}, utilicc.SRGB()...)
+	i := bytes.Index(icc, []byte("curv"))
+	binary.BigEndian.PutUint32(icc[i+8:], 0) // identity curve
+	return append(icc, make([]byte, 70000)...)
+}
+
+func TestTransformICC(t *testing.T) {
+
+	gray := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range gray.Pix {
+		gray.Pix[i] = 128
+	}
+	b := &bytes.Buffer{}
+	if err := png.Encode(b, gray); err != nil {
+		t.Fatal(err)
+	}
+	data := embedPNG(b.Bytes(), &utilexif.Metadata{ICC: linearProfile()})
+
+	if m, err := utilexif.Read(bytes.NewReader(data)); err != nil || !bytes.Equal(m.ICC, linearProfile()) {
+		t.Fatalf("png icc not read: %v", err)
+	}
+
+	for _, convert := range []bool{false, true} {
+		for _, format := range []string{FormatJPEG, FormatPNG, FormatWEBP} {
+			out, err := transform(data, &TransformOptions{Width: 32, Height: 32, ConvertSRGB: convert}, &EncodeOptions{Format: format, Quality: 95, EmbedSRGB: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, _, err := image.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// linear 0.5 is sRGB 188
+			want := 128
+			if convert {
+				want = 188
+			}
+			if v := int(color.GrayModel.Convert(img.At(16, 16)).(color.Gray).Y); format != FormatWEBP && (v < want-3 || v > want+3) { // lossy webp shifts levels
+				t.Fatalf("convert %v %v gray %v, want %v", convert, format, v, want)
+			}
+
+			m, err := utilexif.Read(bytes.NewReader(out))
+			if err != nil || !bytes.Equal(m.ICC, utilicc.SRGB()) {
+				t.Fatalf("convert %v %v srgb profile not embedded: %v", convert, format, err)
+			}
+		}
+	}
+
+	// APP2 chunks
+	jpg := embedJPEG(utiltest.GetTestImage(), &utilexif.Metadata{ICC: linearProfile()})
+	if m, err := utilexif.Read(bytes.NewReader(jpg)); err != nil || !bytes.Equal(m.ICC, linearProfile()) {
+		t.Fatalf("jpeg icc not read: %v", err)
+	}
+}
+
+func TestDrawWatermark(t *testing.T) {
+
+	src := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(src, src.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	logo := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(logo, logo.Rect, image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	// red pixels per quadrant
+	quadrants := func(img *image.RGBA) [4]int {
+		var res [4]int
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 200; x++ {
+				if c := img.RGBAAt(x, y); c.G < 200 && c.R > 200 {
+					res[y/50*2+x/100]++
+				}
+			}
+		}
+		return res
+	}
+
+	t.Run("logo", func(t *testing.T) {
+		img, err := DrawWatermark(src, &WatermarkOptions{Logo: logo, Position: GravityNorthEast, Margin: 5, Opacity: 0.5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := img.RGBAAt(200-5-10, 10); c.R != 255 || c.G < 120 || c.G > 135 {
+			t.Fatalf("logo pixel %v", c)
+		}
+		if c := img.RGBAAt(200-5-21, 10); c != (color.RGBA{255, 255, 255, 255}) {
+			t.Fatalf("outside pixel %v", c)
+		}
+		if q := quadrants(img); q != [4]int{0, 200, 0, 0} {
+			t.Fatalf("quadrants %v", q)
+		}
+	})
+
+	t.Run("scale rotation", func(t *testing.T) {
+		img, err := DrawWatermark(src, &WatermarkOptions{Logo: logo, Scale: 0.2, Rotation: 90, Position: GravitySouthWest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 40x20 logo rotated to 20x40, bilinear edges
+		if q := quadrants(img); q[0] != 0 || q[1] != 0 || q[3] != 0 || q[2] < 760 || q[2] > 800 {
+			t.Fatalf("quadrants %v", q)
+		}
+	})
+
+	t.Run("tile", func(t *testing.T) {
+		for _, mode := range []string{WatermarkTile, WatermarkDiagonal} {
+			img, err := DrawWatermark(src, &WatermarkOptions{Logo: logo, Margin: 10, Mode: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, n := range quadrants(img) {
+				if n < 1000 {
+					t.Fatalf("%v quadrant %v red %v", mode, i, n)
+				}
+			}
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		img, err := DrawWatermark(src, &WatermarkOptions{Text: "EXAMPLE", Color: color.RGBA{255, 0, 0, 255}, FontSize: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := quadrants(img)
+		if q[0] == 0 || q[1] == 0 || q[2] == 0 || q[3] == 0 {
+			t.Fatalf("text not centred: %v", q)
+		}
+	})
+}
+
+func TestBlurHash(t *testing.T) {
+
+	black := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	draw.Draw(black, black.Rect, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	if got := BlurHash(black, 4, 3); got != "L00000fQfQfQfQfQfQfQfQfQfQfQ" {
+		t.Fatalf("black %v", got)
+	}
+
+	img, err := Decode(utiltest.GetTestImage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := BlurHash(img, 4, 3)
+	if len(got) != 28 || got[0] != 'L' || got == BlurHash(black, 4, 3) {
+		t.Fatalf("image %v", got)
+	}
+}
+
+func TestThumbHash(t *testing.T) {
+
+	gray := image.NewRGBA(image.Rect(0, 0, 70, 70))
+	draw.Draw(gray, gray.Rect, image.NewUniform(color.Gray{128}), image.Point{}, draw.Src)
+
+	// l 128/255 of 63, p q 0, no scale, lx 7; 27 l, 5 p, 5 q ac nibbles
+	got := ThumbHash(gray)
+	header24 := 32 | 32<<6 | 32<<12
+	want := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), 7, 0}
+	if len(got) != 5+19 || !bytes.Equal(got[:5], want) {
+		t.Fatalf("gray %v, want %v...", got, want)
+	}
+
+	// scaled to 100x50, landscape
+	wide := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	if got := ThumbHash(wide); got[4]&0x80 == 0 || got[2]&0x80 == 0 {
+		t.Fatalf("transparent landscape %v", got)
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+
+	res, err := Placeholder(utiltest.GetTestImage(), &TransformOptions{Width: 20, Height: 20}, &EncodeOptions{Format: FormatJPEG, Quality: 70})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(res.Image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 949x770
+	if format != "jpeg" || cfg.Width != 20 || cfg.Height != 16 || res.Width != 20 || res.Height != 16 {
+		t.Fatalf("tiny %v %vx%v", format, cfg.Width, cfg.Height)
+	}
+	if len(res.BlurHash) != 28 || len(res.ThumbHash) < 5 {
+		t.Fatalf("hash %v %v", res.BlurHash, res.ThumbHash)
+	}
+}
+
+func TestPalette(t *testing.T) {
+
+	// 60% red, 30% blue, 10% green, transparent column
+	img := image.NewRGBA(image.Rect(0, 0, 11, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			switch {
+			case x >= 9:
+				c = color.RGBA{0, 255, 0, 255}
+			case x >= 6:
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	want := []color.RGBA{{255, 0, 0, 255}, {0, 0, 255, 255}, {0, 255, 0, 255}}
+	for _, k := range []int{3, 5} {
+		if got := Palette(img, k); !slices.Equal(got, want) {
+			t.Fatalf("k %v: %v", k, got)
+		}
+	}
+	if got := Palette(img, 1); len(got) != 1 || got[0] != (color.RGBA{153, 25, 76, 255}) {
+		t.Fatalf("k 1: %v", got)
+	}
+
+	got, err := PaletteOf(utiltest.GetTestImage(), &TransformOptions{}, 5)
+	if err != nil || len(got) != 5 {
+		t.Fatalf("image %v %v", got, err)
+	}
+}
+
+func TestTransformAnimated(t *testing.T) {
+
+	// 40x20 white, red square added per frame on transparent frames, last frame partial
+	pal := color.Palette{color.Transparent, color.RGBA{255, 0, 0, 255}, color.White}
+	src := &gif.GIF{LoopCount: 2}
+	for i := 0; i < 3; i++ {
+		r := image.Rect(0, 0, 40, 20)
+		if i == 2 {
+			r = image.Rect(10, 0, 30, 20)
+		}
+		frame := image.NewPaletted(r, pal)
+		if i == 0 {
+			draw.Draw(frame, r, image.NewUniform(pal[2]), image.Point{}, draw.Src)
+		}
+		draw.Draw(frame, image.Rect(10*i, 0, 10*i+10, 10), image.NewUniform(pal[1]), image.Point{}, draw.Src)
+		src.Image = append(src.Image, frame)
+		src.Delay = append(src.Delay, 10*(i+1))
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, src); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if frames, width, height, err := GIFFrames(data); err != nil || frames != 3 || width != 40 || height != 20 {
+		t.Fatalf("frames %v %vx%v %v", frames, width, height, err)
+	}
+
+	box := &TransformOptions{Width: 20, Height: 20}
+	out, ok, err := TransformAnimated(data, box, &EncodeOptions{Format: FormatGIF}, nil, nil)
+	if err != nil || !ok {
+		t.Fatalf("gif %v %v", ok, err)
+	}
+	res, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Image) != 3 || !slices.Equal(res.Delay, []int{10, 20, 30}) || res.LoopCount != 2 {
+		t.Fatalf("frames %v delay %v loop %v", len(res.Image), res.Delay, res.LoopCount)
+	}
+	if res.Config.Width != 20 || res.Config.Height != 10 {
+		t.Fatalf("size %vx%v", res.Config.Width, res.Config.Height)
+	}
+	// squares of previous frames are kept (DisposalNone), coalesced
+	for _, p := range []image.Point{{2, 2}, {7, 2}, {12, 2}} {
+		if c := color.RGBAModel.Convert(res.Image[2].At(p.X, p.Y)).(color.RGBA); c != (color.RGBA{255, 0, 0, 255}) {
+			t.Fatalf("last frame %v %v", p, c)
+		}
+	}
+	if c := color.RGBAModel.Convert(res.Image[2].At(17, 7)).(color.RGBA); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("last frame background %v", c)
+	}
+
+	out, ok, err = TransformAnimated(data, box, &EncodeOptions{Format: FormatWEBP, Quality: 80}, nil, nil)
+	if err != nil || !ok || string(out[12:16]) != "VP8X" || !bytes.Contains(out, []byte("ANMF")) {
+		t.Fatalf("webp %v %v", ok, err)
+	}
+
+	// limits, not animated
+	for _, a := range []*AnimationOptions{{MaxFrames: 2}, {MaxPixels: 3*40*20 - 1}} {
+		if _, ok, err := TransformAnimated(data, box, &EncodeOptions{Format: FormatGIF}, nil, a); ok || err != nil {
+			t.Fatalf("limit %+v %v %v", a, ok, err)
+		}
+	}
+	if _, ok, err := TransformAnimated(utiltest.GetTestImage(), box, &EncodeOptions{Format: FormatGIF}, nil, nil); ok || err != nil {
+		t.Fatalf("jpeg %v %v", ok, err)
+	}
+}
+
+func TestDecodeMemory(t *testing.T) {
+
+	data := utiltest.GetTestImage()
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+	if got := DecodeMemory(data, cfg, format); got != 7*pixels {
+		t.Fatalf("jpeg %v, want %v", got, 7*pixels)
+	}
+
+	buf := &bytes.Buffer{}
+	img := image.NewNRGBA64(image.Rect(0, 0, 10, 20))
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, _ = image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if got := DecodeMemory(buf.Bytes(), cfg, format); got != 12*200 {
+		t.Fatalf("png 16 bit %v", got)
+	}
+
+	// animated, frames and canvases
+	pal := color.Palette{color.Black, color.White}
+	g := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 10, 20), pal))
+		g.Delay = append(g.Delay, 10)
+	}
+	buf.Reset()
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, _ = image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if got := DecodeMemory(buf.Bytes(), cfg, format); got != (3+8)*200 {
+		t.Fatalf("gif %v", got)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+
+	data := utiltest.GetTestImage()
+
+	// resize, crop, watermark, grayscale; encoded once
+	ops := append(TransformOps(data, &TransformOptions{Width: 300, Height: 300, ScaledDecode: true}),
+		CropOp{Rect: image.Rect(50, 50, 250, 200)},
+		WatermarkOp{Watermark: &WatermarkOptions{Text: "wm", Color: color.RGBA{255, 0, 0, 255}}},
+		GrayscaleOp{},
+	)
+	out, err := (&Pipeline{Ops: ops}).Run(data, &EncodeOptions{Format: FormatPNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 150 {
+		t.Fatalf("size %v", b)
+	}
+	for y := 0; y < 150; y += 7 {
+		for x := 0; x < 200; x += 7 {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if c.R != c.G || c.G != c.B {
+				t.Fatalf("not gray at %v,%v: %v", x, y, c)
+			}
+		}
+	}
+
+	// blur of a step keeps the mean, sharpen increases contrast
+	step := image.NewRGBA(image.Rect(0, 0, 8, 1))
+	for x := 4; x < 8; x++ {
+		step.SetRGBA(x, 0, color.RGBA{200, 200, 200, 255})
+	}
+	for _, v := range []struct {
+		op   Op
+		x    int
+		want uint8
+	}{
+		{BlurOp{Radius: 1}, 3, 67},
+		{BlurOp{Radius: 1}, 4, 133},
+		{SharpenOp{Amount: 1}, 3, 0},
+		{SharpenOp{Amount: 1}, 4, 255},
+	} {
+		f := &Frame{Image: step, Width: 8, Height: 1}
+		if err := v.op.Apply(f); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Image.(*image.RGBA).RGBAAt(v.x, 0).R; got != v.want {
+			t.Fatalf("%T at %v: %v, want %v", v.op, v.x, got, v.want)
+		}
+	}
+
+	for _, v := range []struct {
+		s    string
+		want Op
+	}{
+		{"grayscale", GrayscaleOp{}},
+		{"blur", BlurOp{Radius: 2}},
+		{"blur:4", BlurOp{Radius: 4}},
+		{"sharpen:1.5", SharpenOp{Amount: 1.5}},
+		{"blur:0", nil},
+		{"grayscale:1", nil},
+		{"sepia", nil},
+	} {
+		got, err := ParseFilter(v.s)
+		if got != v.want || (err == nil) != (v.want != nil) {
+			t.Fatalf("%q: %v %v", v.s, got, err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package utilimage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// watermark modes
+const (
+	WatermarkSingle   = "single"   // one mark at Position
+	WatermarkTile     = "tile"     // repeated in a grid
+	WatermarkDiagonal = "diagonal" // repeated in shifted rows, rotated 45 degrees if no Rotation
+)
+
+// DefaultWatermarkColor of text, light and translucent (premultiplied)
+var DefaultWatermarkColor = color.RGBA{65, 65, 65, 65}
+
+// WatermarkOptions text or logo mark, zero values are the default style (centred text)
+type WatermarkOptions struct {
+	Text     string
+	Font     *opentype.Font // nil is embedded Roboto
+	FontSize float64        // px, 0 is image height/10 in 24..36
+	Color    color.Color    // text, nil is DefaultWatermarkColor
+
+	Logo  image.Image // drawn instead of Text if not nil
+	Scale float64     // logo width relative to image width, 0 is logo size
+
+	Position string  // single mark anchor: GravityCenter (default), GravityNorthEast ...
+	Margin   int     // px, from the edges (single) or between marks (tile, diagonal)
+	Opacity  float64 // 0..1 of the mark, 0 is 1
+	Rotation float64 // degrees counter-clockwise
+	Mode     string  // WatermarkSingle (default), WatermarkTile, WatermarkDiagonal
+}
+
+// IsWatermarkMode valid mode, "" is WatermarkSingle
+func IsWatermarkMode(mode string) bool {
+
+	switch mode {
+	case "", WatermarkSingle, WatermarkTile, WatermarkDiagonal:
+		return true
+	}
+	return false
+}
+
+// ParseFont TTF or OTF data
+func ParseFont(data []byte) (*opentype.Font, error) {
+
+	fnt, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error font not valid: %v", err)
+	}
+	return fnt, nil
+}
+
+// DrawWatermark copy of img with watermark
+func DrawWatermark(img image.Image, w *WatermarkOptions) (*image.RGBA, error) {
+
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+
+	var mark *image.RGBA
+	{
+		var err error
+		if w.Logo != nil {
+			mark = logoMark(w.Logo, w.Scale, dst.Rect.Dx())
+		} else {
+			mark, err = textMark(w, dst.Rect.Dy())
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rotation := w.Rotation
+		if w.Mode == WatermarkDiagonal && rotation == 0 {
+			rotation = 45
+		}
+		mark = rotate(mark, rotation)
+	}
+
+	if mark.Rect.Empty() {
+		return dst, nil
+	}
+
+	opacity := w.Opacity
+	if opacity <= 0 || opacity > 1 {
+		opacity = 1
+	}
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(255 * opacity))})
+
+	for _, pt := range markPoints(dst.Rect.Size(), mark.Rect.Size(), w) {
+		r := image.Rectangle{Min: pt, Max: pt.Add(mark.Rect.Size())}
+		draw.DrawMask(dst, r, mark, image.Point{}, mask, image.Point{}, draw.Over)
+	}
+
+	return dst, nil
+}
+
+// textMark text of w on transparent image of text size
+func textMark(w *WatermarkOptions, imageHeight int) (*image.RGBA, error) {
+
+	fnt := w.Font
+	if fnt == nil {
+		var err error
+		fnt, err = getFontWatermark()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get font: %v", err)
+		}
+	}
+
+	size := w.FontSize
+	if size <= 0 {
+		size = math.Min(36, math.Max(24, float64(imageHeight/10)))
+	}
+
+	face, err := opentype.NewFace(fnt, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create font face: %v", err)
+	}
+	defer face.Close()
+
+	col := w.Color
+	if col == nil {
+		col = DefaultWatermarkColor
+	}
+
+	d := &font.Drawer{Face: face}
+	m := face.Metrics()
+	width := d.MeasureString(w.Text).Ceil()
+	height := (m.Ascent + m.Descent).Ceil()
+
+	res := image.NewRGBA(image.Rect(0, 0, width, height))
+	d.Dst = res
+	d.Src = image.NewUniform(col)
+	d.Dot = fixed.Point26_6{X: 0, Y: m.Ascent}
+	d.DrawString(w.Text)
+
+	return res, nil
+}
+
+// logoMark logo scaled to scale of imageWidth, logo size if scale is 0
+func logoMark(logo image.Image, scale float64, imageWidth int) *image.RGBA {
+
+	b := logo.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if scale > 0 && width > 0 {
+		width = max(1, int(math.Round(scale*float64(imageWidth))))
+		height = max(1, height*width/b.Dx())
+	}
+
+	res := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(res, res.Rect, logo, b, draw.Src, nil)
+
+	return res
+}
+
+// rotate by degrees counter-clockwise into the bounding box, src if 0
+func rotate(src *image.RGBA, degrees float64) *image.RGBA {
+
+	if math.Mod(degrees, 360) == 0 {
+		return src
+	}
+
+	rad := degrees * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+
+	w, h := float64(src.Rect.Dx()), float64(src.Rect.Dy())
+	dw := math.Ceil(math.Abs(w*cos) + math.Abs(h*sin))
+	dh := math.Ceil(math.Abs(w*sin) + math.Abs(h*cos))
+	dst := image.NewRGBA(image.Rect(0, 0, int(dw), int(dh)))
+
+	// src to dst, around centres (y is down)
+	cx, cy, dcx, dcy := w/2, h/2, dw/2, dh/2
+	m := f64.Aff3{
+		cos, sin, dcx - cos*cx - sin*cy,
+		-sin, cos, dcy + sin*cx - cos*cy,
+	}
+	draw.BiLinear.Transform(dst, m, src, src.Rect, draw.Src, nil)
+
+	return dst
+}
+
+// markPoints top left of marks by mode
+func markPoints(size image.Point, mark image.Point, w *WatermarkOptions) []image.Point {
+
+	switch w.Mode {
+	case WatermarkTile, WatermarkDiagonal:
+		stepX, stepY := mark.X+max(0, w.Margin), mark.Y+max(0, w.Margin)
+		// grid centred on the image, partial marks on the edges
+		cols, rows := size.X/stepX+2, size.Y/stepY+2
+		x0, y0 := (size.X-cols*stepX+w.Margin)/2, (size.Y-rows*stepY+w.Margin)/2
+
+		res := []image.Point{}
+		for row := 0; row < rows; row++ {
+			shift := 0
+			if w.Mode == WatermarkDiagonal && row%2 == 1 {
+				shift = stepX / 2
+			}
+			for col := -1; col < cols; col++ {
+				res = append(res, image.Pt(x0+col*stepX+shift, y0+row*stepY))
+			}
+		}
+		return res
+	}
+
+	gravity, ok := gravities[w.Position]
+	if !ok {
+		gravity = gravities[GravityCenter]
+	}
+	margin := max(0, w.Margin)
+	x := margin + (size.X-2*margin-mark.X)*gravity[0]/2
+	y := margin + (size.Y-2*margin-mark.Y)*gravity[1]/2
+	return []image.Point{image.Pt(x, y)}
+}
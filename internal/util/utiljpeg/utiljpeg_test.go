@@ -0,0 +1,139 @@
+package utiljpeg
+
+import (
+	"bytes"
+	"go-image/internal/util/utiltest"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// meanDiff of scaled vs box average of full, 0..255
+func meanDiff(full image.Image, scaled image.Image, scale int) float64 {
+
+	fb, sb := full.Bounds(), scaled.Bounds()
+	var diff float64
+	for y := 0; y < sb.Dy(); y++ {
+		for x := 0; x < sb.Dx(); x++ {
+			var sum, n float64
+			for yy := y * scale; yy < min(y*scale+scale, fb.Dy()); yy++ {
+				for xx := x * scale; xx < min(x*scale+scale, fb.Dx()); xx++ {
+					sum += float64(color.GrayModel.Convert(full.At(fb.Min.X+xx, fb.Min.Y+yy)).(color.Gray).Y)
+					n++
+				}
+			}
+			v := float64(color.GrayModel.Convert(scaled.At(sb.Min.X+x, sb.Min.Y+y)).(color.Gray).Y)
+			if d := sum/n - v; d < 0 {
+				diff -= d
+			} else {
+				diff += d
+			}
+		}
+	}
+	return diff / float64(sb.Dx()*sb.Dy())
+}
+
+func TestDecodeScaled(t *testing.T) {
+
+	data := utiltest.GetTestImage()
+
+	full, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h := full.Bounds().Dx(), full.Bounds().Dy()
+
+	for _, scale := range []int{1, 2, 4, 8} {
+		img, err := DecodeScaled(bytes.NewReader(data), scale)
+		if err != nil {
+			t.Fatalf("scale %v: %v", scale, err)
+		}
+		if b := img.Bounds(); b.Dx() != (w+scale-1)/scale || b.Dy() != (h+scale-1)/scale {
+			t.Fatalf("scale %v size %v, from %vx%v", scale, b, w, h)
+		}
+		if d := meanDiff(full, img, scale); d > 3 {
+			t.Fatalf("scale %v mean diff %.2f", scale, d)
+		}
+	}
+
+	if _, err := DecodeScaled(bytes.NewReader(data), 3); err == nil {
+		t.Fatal("scale 3 must fail")
+	}
+}
+
+func TestDecodeScaledGray(t *testing.T) {
+
+	src := image.NewGray(image.Rect(0, 0, 100, 60))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i % 251)
+	}
+	b := &bytes.Buffer{}
+	if err := jpeg.Encode(b, src, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := DecodeScaled(bytes.NewReader(b.Bytes()), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.Gray); !ok || img.Bounds() != image.Rect(0, 0, 13, 8) {
+		t.Fatalf("got %T %v", img, img.Bounds())
+	}
+}
+
+func TestScale(t *testing.T) {
+
+	tests := []struct {
+		srcW, srcH, w, h, want int
+	}{
+		{4000, 3000, 200, 150, 8},
+		{4000, 3000, 800, 600, 4},
+		{4000, 3000, 1000, 600, 4},
+		{4000, 3000, 1001, 600, 2},
+		{4000, 3000, 3000, 100, 1},
+		{100, 100, 0, 0, 8},
+	}
+	for _, tt := range tests {
+		if got := Scale(tt.srcW, tt.srcH, tt.w, tt.h); got != tt.want {
+			t.Errorf("Scale(%v, %v, %v, %v) = %v, want %v", tt.srcW, tt.srcH, tt.w, tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestIDCTReduced(t *testing.T) {
+
+	for _, scale := range []int{2, 4} {
+		// flat block: every average is DC/8
+		b := &block{}
+		b[0] = -800
+		idctReduced(b, scale)
+		for y := range 8 / scale {
+			for x := range 8 / scale {
+				if b[y*8+x] != -100 {
+					t.Fatalf("scale %v flat %v,%v: %v", scale, x, y, b[y*8+x])
+				}
+			}
+		}
+
+		// low frequency: same as averages of the full IDCT
+		b, full := &block{}, &block{}
+		b[0], b[1], b[8], b[9] = 400, -120, 90, 40
+		*full = *b
+		idctReduced(b, scale)
+		idct(full)
+		for y := range 8 / scale {
+			for x := range 8 / scale {
+				sum := int32(0)
+				for yy := range scale {
+					for xx := range scale {
+						sum += full[(y*scale+yy)*8+x*scale+xx]
+					}
+				}
+				if d := b[y*8+x] - sum/int32(scale*scale); d < -1 || d > 1 {
+					t.Fatalf("scale %v at %v,%v: %v, full %v", scale, x, y, b[y*8+x], sum/int32(scale*scale))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package utilpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrOverBudget amount is larger than the whole budget, never fits
+var ErrOverBudget = errors.New("error over budget")
+
+// Budget shared amount (e.g. bytes of decoded pixels) of concurrent jobs,
+// Acquire waits for a free amount in FIFO order
+type Budget struct {
+	sem  *semaphore.Weighted // nil is not limited
+	size int64
+	used atomic.Int64
+}
+
+// NewBudget size <= 0 is not limited
+func NewBudget(size int64) *Budget {
+
+	res := &Budget{size: size}
+	if size > 0 {
+		res.sem = semaphore.NewWeighted(size)
+	}
+	return res
+}
+
+// Acquire wait until n is free, release returns it;
+// ErrOverBudget if n is larger than size
+func (x *Budget) Acquire(n int64) (release func(), err error) {
+
+	n = max(n, 0)
+	if x.sem != nil {
+		if n > x.size {
+			return nil, ErrOverBudget
+		}
+		if err := x.sem.Acquire(context.Background(), n); err != nil {
+			return nil, err
+		}
+	}
+	x.used.Add(n)
+
+	return func() {
+		x.used.Add(-n)
+		if x.sem != nil {
+			x.sem.Release(n)
+		}
+	}, nil
+}
+
+// Used acquired amount
+func (x *Budget) Used() int64 {
+	return x.used.Load()
+}
+
+// Size limit, 0 if not limited
+func (x *Budget) Size() int64 {
+	return max(x.size, 0)
+}
@@ -0,0 +1,48 @@
+// Package utilpool bounded job pool
+package utilpool
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrBusy job rejected, all workers busy and queue full
+var ErrBusy = errors.New("error pool busy")
+
+// Pool runs at most maxJobs jobs at once, at most queueDepth jobs wait
+type Pool struct {
+	sem     chan struct{}
+	pending atomic.Int64 // running + waiting
+	limit   int64
+}
+
+// NewPool maxJobs >= 1, queueDepth >= 0
+func NewPool(maxJobs int, queueDepth int) *Pool {
+	maxJobs = max(maxJobs, 1)
+	queueDepth = max(queueDepth, 0)
+	return &Pool{
+		sem:   make(chan struct{}, maxJobs),
+		limit: int64(maxJobs + queueDepth),
+	}
+}
+
+// Do run fn in the caller goroutine once a worker slot is free,
+// ErrBusy if queue is full
+func (x *Pool) Do(fn func() error) error {
+
+	if x.pending.Add(1) > x.limit {
+		x.pending.Add(-1)
+		return ErrBusy
+	}
+	defer x.pending.Add(-1)
+
+	x.sem <- struct{}{}
+	defer func() { <-x.sem }()
+
+	return fn()
+}
+
+// Pending running + waiting jobs
+func (x *Pool) Pending() int {
+	return int(x.pending.Load())
+}
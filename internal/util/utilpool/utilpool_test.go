@@ -0,0 +1,131 @@
+package utilpool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+
+	const maxJobs, queueDepth = 2, 3
+
+	p := NewPool(maxJobs, queueDepth)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, maxJobs)
+
+	var running, peak atomic.Int64
+	job := func() error {
+		n := running.Add(1)
+		for {
+			v := peak.Load()
+			if n <= v || peak.CompareAndSwap(v, n) {
+				break
+			}
+		}
+		started <- struct{}{}
+		<-release
+		running.Add(-1)
+		return nil
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, maxJobs+queueDepth)
+	for i := 0; i < maxJobs+queueDepth; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- p.Do(job)
+		}()
+	}
+
+	// workers busy
+	for i := 0; i < maxJobs; i++ {
+		<-started
+	}
+	// wait queue full
+	for p.Pending() < maxJobs+queueDepth {
+		continue
+	}
+
+	if err := p.Do(func() error { return nil }); !errors.Is(err, ErrBusy) {
+		t.Fatalf("err %v, want ErrBusy", err)
+	}
+
+	go func() {
+		for range started {
+			continue
+		}
+	}()
+	close(release)
+	wg.Wait()
+	close(errs)
+	close(started)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if v := peak.Load(); v > maxJobs {
+		t.Fatalf("peak %v > %v", v, maxJobs)
+	}
+	if v := p.Pending(); v != 0 {
+		t.Fatalf("pending %v", v)
+	}
+}
+
+func TestBudget(t *testing.T) {
+
+	b := NewBudget(100)
+
+	if _, err := b.Acquire(101); !errors.Is(err, ErrOverBudget) {
+		t.Fatalf("err %v, want ErrOverBudget", err)
+	}
+
+	release, err := b.Acquire(70)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// waits for the first release
+	acquired := make(chan func())
+	go func() {
+		r, err := b.Acquire(50)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- r
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired over budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if v := b.Used(); v != 70 {
+		t.Fatalf("used %v", v)
+	}
+
+	release()
+	release2 := <-acquired
+	if v := b.Used(); v != 50 {
+		t.Fatalf("used %v", v)
+	}
+	release2()
+
+	if v := b.Used(); v != 0 {
+		t.Fatalf("used %v", v)
+	}
+
+	// not limited
+	release, err = NewBudget(0).Acquire(1 << 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
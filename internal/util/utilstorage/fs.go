@@ -0,0 +1,137 @@
+package utilstorage
+
+import (
+	"errors"
+	"fmt"
+	"go-image/internal/util/utilfile"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FS storage in local dir, key is the relative path; temp files of atomic writes are not listed
+type FS struct {
+	dir string
+}
+
+func NewFS(dir string) *FS {
+	return &FS{dir: dir}
+}
+
+// Local dir of keys, always
+func (x *FS) Local() (string, bool) {
+	return x.dir, true
+}
+
+// Path file of key
+func (x *FS) Path(key string) string {
+	return keyPath(x.dir, key)
+}
+
+func (x *FS) Get(key string) ([]byte, error) {
+
+	return os.ReadFile(x.Path(key))
+}
+
+func (x *FS) Open(key string) (io.ReadCloser, error) {
+
+	return os.Open(x.Path(key))
+}
+
+func (x *FS) Put(key string, data []byte) error {
+
+	file := x.Path(key)
+
+	err := utilfile.MakeAllDirs(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+
+	return utilfile.FileWriteAtomic(file, data)
+}
+
+func (x *FS) Stat(key string) (*Info, error) {
+
+	info, err := os.Stat(x.Path(key))
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, ErrNotExist
+	}
+
+	return &Info{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (x *FS) Delete(key string) error {
+
+	err := os.Remove(x.Path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List walks the dir of prefix only
+func (x *FS) List(prefix string) ([]*Info, error) {
+
+	root := x.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = x.Path(prefix[:i])
+	}
+
+	res := []*Info{}
+
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // removed meanwhile, or no dir of prefix
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), utilfile.TempSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(x.dir, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed meanwhile
+		}
+		res = append(res, &Info{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+
+	return res, err
+}
+
+// Find stat per ext, local and cheap
+func (x *FS) Find(base string, exts []string) (*Info, error) {
+
+	for _, ext := range exts {
+		info, err := x.Stat(base + ext)
+		if err == nil {
+			return info, nil
+		}
+		if !errors.Is(err, ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("find %v: %w", base, ErrNotExist)
+}
+
+func (x *FS) Touch(key string, t time.Time) error {
+
+	return os.Chtimes(x.Path(key), t, t)
+}
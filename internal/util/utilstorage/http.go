@@ -0,0 +1,583 @@
+package utilstorage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// ErrReadOnly Put of HTTP without Local
+	ErrReadOnly = errors.New("error storage is read only")
+	// ErrTooLarge body of origin over MaxBytes
+	ErrTooLarge = errors.New("error source image too large")
+
+	errNoHead = errors.New("error origin does not allow HEAD")
+)
+
+const (
+	httpStatMax = 10000    // entries of stat cache, expired are dropped over it
+	httpBodyMax = 64 << 20 // bytes of origin bodies kept for Revalidate without Local, dropped over it
+)
+
+// httpExts ext of keys by origin media type
+var httpExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+	"image/bmp":  ".bmp",
+	"image/tiff": ".tif",
+}
+
+// HTTPOptions origin of objects, key "a-1/a-1.jpg" is fetched as URL with {id} "a-1" (base name without ext):
+// one URL per id, the ext of its key is of the origin Content-Type (sniffed if not an image type, else of URL)
+type HTTPOptions struct {
+	URL          string        // template "https://origin/{id}.jpg"
+	MaxBytes     int64         // of body, larger are refused
+	ContentTypes []string      // allowed media types, any if empty
+	NotFoundTTL  time.Duration // 404 and 410 are cached for it
+	Revalidate   time.Duration // found stat (and body without Local) is cached for it, stored objects of Local are checked after it
+	Local        Storage       // fetched objects are stored and read first, Put Delete List are of it; nil none
+}
+
+// HTTP read-through storage of an origin server
+type HTTP struct {
+	opts   HTTPOptions
+	client *http.Client
+	flight singleflight.Group // one fetch per url
+	urlExt string             // of URL template, if an ext of httpExts
+
+	mu        sync.Mutex
+	stats     map[string]*httpStat // url; of Local the last check
+	bodyBytes int64                // of stats data
+}
+
+type httpStat struct {
+	info    *Info  // Key of origin ext, nil if not exists
+	data    []byte // body of origin without Local, nil if not fetched
+	expires time.Time
+}
+
+func NewHTTP(opts HTTPOptions, client *http.Client) (*HTTP, error) {
+
+	u, err := url.Parse(strings.ReplaceAll(opts.URL, "{id}", "id"))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") || !strings.Contains(opts.URL, "{id}") {
+		return nil, fmt.Errorf("error origin url not valid: %v", opts.URL)
+	}
+	if opts.MaxBytes < 1 {
+		return nil, fmt.Errorf("error origin max bytes is not set")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res := &HTTP{opts: opts, client: client, stats: map[string]*httpStat{}}
+	for _, v := range httpExts {
+		if strings.EqualFold(path.Ext(u.Path), v) {
+			res.urlExt = v
+		}
+	}
+	return res, nil
+}
+
+// splitKey "a-1/a-1.jpg" => "a-1/a-1", ".jpg"
+func splitKey(key string) (base string, ext string) {
+
+	ext = path.Ext(key)
+	return strings.TrimSuffix(key, ext), ext
+}
+
+// url of base key (without ext), {id} is path escaped
+func (x *HTTP) url(base string) string {
+
+	return strings.ReplaceAll(x.opts.URL, "{id}", url.PathEscape(path.Base(base)))
+}
+
+// stat cached of u, ok false if not cached or expired
+func (x *HTTP) stat(u string) (info *Info, data []byte, ok bool) {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	v := x.stats[u]
+	if v == nil || time.Now().After(v.expires) {
+		return nil, nil, false
+	}
+	return v.info, v.data, true
+}
+
+func (x *HTTP) setStat(u string, info *Info, data []byte, ttl time.Duration) {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if v := x.stats[u]; v != nil {
+		x.bodyBytes -= int64(len(v.data))
+	}
+
+	if ttl <= 0 {
+		delete(x.stats, u)
+		return
+	}
+
+	if len(x.stats) >= httpStatMax || x.bodyBytes+int64(len(data)) > httpBodyMax {
+		now := time.Now()
+		for k, v := range x.stats {
+			if now.After(v.expires) {
+				x.bodyBytes -= int64(len(v.data))
+				delete(x.stats, k)
+			}
+		}
+		if len(x.stats) >= httpStatMax {
+			x.stats = map[string]*httpStat{} // many ids at once
+			x.bodyBytes = 0
+		}
+		if x.bodyBytes+int64(len(data)) > httpBodyMax {
+			for _, v := range x.stats {
+				v.data = nil // fetched again if needed
+			}
+			x.bodyBytes = 0
+		}
+	}
+
+	if int64(len(data)) > httpBodyMax {
+		data = nil
+	}
+	x.stats[u] = &httpStat{info: info, data: data, expires: time.Now().Add(ttl)}
+	x.bodyBytes += int64(len(data))
+}
+
+// request u, resp is 2xx with allowed content type; ErrNotExist if 404 or 410,
+// errNoHead if HEAD is 405 or 501
+func (x *HTTP) request(method string, u string) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "identity") // Content-Length is of the image
+
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = func() error {
+		switch {
+		case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+			return fmt.Errorf("origin %v: %w", u, ErrNotExist)
+		case method == http.MethodHead &&
+			(resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented):
+			return errNoHead
+		case resp.StatusCode < 200 || resp.StatusCode >= 300:
+			return fmt.Errorf("error origin %v: %v", u, resp.Status)
+		}
+
+		if len(x.opts.ContentTypes) > 0 {
+			ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			if !slices.Contains(x.opts.ContentTypes, ct) {
+				return fmt.Errorf("error origin %v: content type not allowed: %q", u, ct)
+			}
+		}
+		return nil
+	}()
+
+	if err != nil {
+		_ = resp.Body.Close()
+		if errors.Is(err, ErrNotExist) {
+			x.setStat(u, nil, nil, x.opts.NotFoundTTL)
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func modTime(resp *http.Response) time.Time {
+
+	t, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return t
+}
+
+// keyExt of origin response by Content-Type; of data (GET) sniffed if not an image type,
+// else of URL template; "" if not known
+func (x *HTTP) keyExt(resp *http.Response, data []byte) string {
+
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if ext, ok := httpExts[ct]; ok {
+		return ext
+	}
+	if data == nil {
+		return "" // of HEAD, GET sniffs
+	}
+
+	ct, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	if ext, ok := httpExts[ct]; ok {
+		return ext
+	}
+	return x.urlExt
+}
+
+type httpResult struct {
+	data []byte
+	info *Info  // of origin, Key not set
+	ext  string // of key, by content type
+}
+
+// get body of u from origin, one request per url at once
+func (x *HTTP) get(u string) (*httpResult, error) {
+
+	if info, _, ok := x.stat(u); ok && info == nil {
+		return nil, fmt.Errorf("origin %v: %w", u, ErrNotExist) // negative cached
+	}
+
+	v, err, _ := x.flight.Do(u, func() (any, error) {
+		resp, err := x.request(http.MethodGet, u)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.ContentLength > x.opts.MaxBytes {
+			return nil, fmt.Errorf("%w: origin %v: %d bytes, limit %d", ErrTooLarge, u, resp.ContentLength, x.opts.MaxBytes)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, x.opts.MaxBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > x.opts.MaxBytes {
+			return nil, fmt.Errorf("%w: origin %v: over %d bytes", ErrTooLarge, u, x.opts.MaxBytes)
+		}
+
+		ext := x.keyExt(resp, data)
+		if ext == "" {
+			return nil, fmt.Errorf("error origin %v: content type not known: %q", u, resp.Header.Get("Content-Type"))
+		}
+
+		return &httpResult{data: data, info: &Info{Size: int64(len(data)), ModTime: modTime(resp)}, ext: ext}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*httpResult), nil
+}
+
+// head info of u at origin, by get if HEAD is not allowed, has no size or no image type (data is set then)
+func (x *HTTP) head(u string) (*httpResult, error) {
+
+	if info, _, ok := x.stat(u); ok && info == nil {
+		return nil, fmt.Errorf("origin %v: %w", u, ErrNotExist) // negative cached
+	}
+
+	resp, err := x.request(http.MethodHead, u)
+	if errors.Is(err, errNoHead) {
+		return x.get(u)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	ext := x.keyExt(resp, nil)
+	if resp.ContentLength < 0 || ext == "" {
+		return x.get(u)
+	}
+
+	return &httpResult{info: &Info{Size: resp.ContentLength, ModTime: modTime(resp)}, ext: ext}, nil
+}
+
+// notExist of key at origin, other ext there
+func notExist(key string) error {
+	return fmt.Errorf("origin %v: %w", key, ErrNotExist)
+}
+
+// origin stat of base without Local, cached; Key of the origin ext
+func (x *HTTP) origin(base string) (*Info, error) {
+
+	u := x.url(base)
+
+	if info, _, ok := x.stat(u); ok {
+		if info == nil {
+			return nil, notExist(base)
+		}
+		return &Info{Key: info.Key, Size: info.Size, ModTime: info.ModTime}, nil
+	}
+
+	res, err := x.head(u)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Key: base + res.ext, Size: res.info.Size, ModTime: res.info.ModTime}
+	x.setStat(u, info, res.data, x.opts.Revalidate) // body if HEAD was a GET
+	return &Info{Key: info.Key, Size: info.Size, ModTime: info.ModTime}, nil
+}
+
+// store res of key in Local, its stat
+func (x *HTTP) store(key string, res *httpResult) (*Info, error) {
+
+	if err := x.opts.Local.Put(key, res.data); err != nil {
+		return nil, err
+	}
+	info, err := x.opts.Local.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	base, _ := splitKey(key)
+	x.setStat(x.url(base), info, nil, x.opts.Revalidate)
+	return info, nil
+}
+
+// fetch body of base from origin if its ext is one of exts (ErrNotExist if not),
+// stored in Local if any, kept for Revalidate if not
+func (x *HTTP) fetch(base string, exts []string) ([]byte, *Info, error) {
+
+	u := x.url(base)
+
+	if x.opts.Local == nil {
+		if info, data, ok := x.stat(u); ok && info != nil && data != nil {
+			if !slices.Contains(exts, path.Ext(info.Key)) {
+				return nil, nil, notExist(base)
+			}
+			return data, &Info{Key: info.Key, Size: info.Size, ModTime: info.ModTime}, nil
+		}
+	}
+
+	res, err := x.get(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := base + res.ext
+	if x.opts.Local != nil {
+		if !slices.Contains(exts, res.ext) {
+			return nil, nil, notExist(base) // not stored, must not shadow one of exts
+		}
+		info, err := x.store(key, res)
+		return res.data, info, err
+	}
+
+	info := &Info{Key: key, Size: res.info.Size, ModTime: res.info.ModTime}
+	x.setStat(u, info, res.data, x.opts.Revalidate)
+	if !slices.Contains(exts, res.ext) {
+		return nil, nil, notExist(base)
+	}
+	return res.data, &Info{Key: key, Size: info.Size, ModTime: info.ModTime}, nil
+}
+
+// revalidate stored info of Local against the origin once per Revalidate, fetched again
+// if changed there after it was stored (Key is of the new ext if the type changed).
+// Not found or failing origin keeps the stored copy (uploaded, or origin down)
+func (x *HTTP) revalidate(info *Info) (*Info, error) {
+
+	base, _ := splitKey(info.Key)
+	u := x.url(base)
+	if _, _, ok := x.stat(u); ok {
+		return info, nil // checked recently
+	}
+
+	res, err := x.head(u)
+	if err != nil {
+		x.setStat(u, info, nil, x.opts.Revalidate)
+		return info, nil
+	}
+
+	changed := res.info.ModTime.After(info.ModTime)
+	if res.info.ModTime.IsZero() {
+		changed = res.info.Size != info.Size // no Last-Modified
+	}
+	if !changed {
+		x.setStat(u, info, nil, x.opts.Revalidate)
+		return info, nil
+	}
+
+	if res.data == nil {
+		res, err = x.get(u)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key := base + res.ext
+	if key != info.Key {
+		// one original per id, the older must not be found first
+		if err := x.opts.Local.Delete(info.Key); err != nil {
+			return nil, err
+		}
+	}
+	return x.store(key, res)
+}
+
+// localStat of key stored in Local, fetched if not stored yet and the origin is of its ext; revalidated
+func (x *HTTP) localStat(key string) (*Info, error) {
+
+	info, err := x.opts.Local.Stat(key)
+	if errors.Is(err, ErrNotExist) {
+		base, ext := splitKey(key)
+		_, info, err = x.fetch(base, []string{ext})
+		return info, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err = x.revalidate(info)
+	if err != nil {
+		return nil, err
+	}
+	if info.Key != key {
+		return nil, notExist(key)
+	}
+	return info, nil
+}
+
+func (x *HTTP) Get(key string) ([]byte, error) {
+
+	if x.opts.Local != nil {
+		if _, err := x.localStat(key); err != nil {
+			return nil, err
+		}
+		return x.opts.Local.Get(key)
+	}
+
+	base, ext := splitKey(key)
+	data, _, err := x.fetch(base, []string{ext})
+	return data, err
+}
+
+// Open of Local, or of the kept body, or streamed from origin: not shared, not cached, cut at MaxBytes
+func (x *HTTP) Open(key string) (io.ReadCloser, error) {
+
+	if x.opts.Local != nil {
+		if _, err := x.localStat(key); err != nil {
+			return nil, err
+		}
+		return x.opts.Local.Open(key)
+	}
+
+	if _, err := x.Stat(key); err != nil {
+		return nil, err // other ext, usually cached by Find
+	}
+
+	base, _ := splitKey(key)
+	u := x.url(base)
+
+	if _, data, ok := x.stat(u); ok && data != nil {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	resp, err := x.request(http.MethodGet, u)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > x.opts.MaxBytes {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%w: origin %v: %d bytes, limit %d", ErrTooLarge, u, resp.ContentLength, x.opts.MaxBytes)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, x.opts.MaxBytes), resp.Body}, nil
+}
+
+// Stat of Local, or of origin by HEAD (GET if HEAD is not allowed or has no size); ErrNotExist if
+// the origin is of another ext
+func (x *HTTP) Stat(key string) (*Info, error) {
+
+	if x.opts.Local != nil {
+		return x.localStat(key)
+	}
+
+	base, _ := splitKey(key)
+	info, err := x.origin(base)
+	if err != nil {
+		return nil, err
+	}
+	if info.Key != key {
+		return nil, notExist(key)
+	}
+	return info, nil
+}
+
+func (x *HTTP) Put(key string, data []byte) error {
+
+	if x.opts.Local == nil {
+		return ErrReadOnly
+	}
+	base, _ := splitKey(key)
+	x.setStat(x.url(base), nil, nil, 0)
+	return x.opts.Local.Put(key, data)
+}
+
+// Delete of Local, origin is not changed
+func (x *HTTP) Delete(key string) error {
+
+	base, _ := splitKey(key)
+	x.setStat(x.url(base), nil, nil, 0)
+	if x.opts.Local == nil {
+		return nil
+	}
+	return x.opts.Local.Delete(key)
+}
+
+// Find of Local, revalidated, or of origin by one request per id: its ext by content type,
+// stored in Local under it
+func (x *HTTP) Find(base string, exts []string) (*Info, error) {
+
+	if x.opts.Local == nil {
+		info, err := x.origin(base)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(exts, path.Ext(info.Key)) {
+			return nil, notExist(base)
+		}
+		return info, nil
+	}
+
+	info, err := x.opts.Local.Find(base, exts)
+	if errors.Is(err, ErrNotExist) {
+		_, info, err = x.fetch(base, exts)
+		return info, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err = x.revalidate(info)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(exts, path.Ext(info.Key)) {
+		return nil, notExist(base)
+	}
+	return info, nil
+}
+
+// Local never, fetched objects of Local are revalidated
+func (x *HTTP) Local() (string, bool) {
+	return "", false
+}
+
+// List of Local, origin is not listed
+func (x *HTTP) List(prefix string) ([]*Info, error) {
+
+	if x.opts.Local == nil {
+		return []*Info{}, nil
+	}
+	return x.opts.Local.List(prefix)
+}
@@ -0,0 +1,315 @@
+package utilstorage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	s3DefaultRegion = "us-east-1"
+	s3ErrorBody     = 512 // bytes of error response in error
+)
+
+// S3Options S3-compatible endpoint (AWS, MinIO, ...), path-style urls endpoint/bucket/prefix+key
+type S3Options struct {
+	Endpoint  string // http(s)://host:port
+	Region    string // default us-east-1
+	Bucket    string
+	Prefix    string // of object keys, "images/"
+	AccessKey string
+	SecretKey string
+}
+
+// S3 storage over the S3 REST api, requests signed by AWS Signature Version 4
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+func NewS3(opts S3Options, client *http.Client) (*S3, error) {
+
+	u, err := url.Parse(strings.TrimSuffix(opts.Endpoint, "/"))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("error s3 endpoint not valid: %v", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("error s3 bucket is empty")
+	}
+	if opts.Region == "" {
+		opts.Region = s3DefaultRegion
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &S3{opts: opts, endpoint: u, client: client, now: time.Now}, nil
+}
+
+// url of object key ("" is the bucket), query is canonical: sorted, encoded
+func (x *S3) url(key string, query string) *url.URL {
+
+	p := "/" + x.opts.Bucket
+	if key != "" {
+		p += "/" + x.opts.Prefix + key
+	}
+
+	u := *x.endpoint
+	u.Path = u.Path + p
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = query
+	return &u
+}
+
+func (x *S3) do(method string, u *url.URL, body []byte) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	hash := sha256.Sum256(body)
+	x.sign(req, hex.EncodeToString(hash[:]), x.now())
+
+	return x.client.Do(req)
+}
+
+// check status of resp, ErrNotExist if 404; body is closed on error
+func (x *S3) check(resp *http.Response, method string, key string) error {
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("s3 %v %v: %w", method, key, ErrNotExist)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, s3ErrorBody))
+	return fmt.Errorf("error s3 %v %v: %v %s", method, key, resp.Status, msg)
+}
+
+func (x *S3) Get(key string) ([]byte, error) {
+
+	resp, err := x.do(http.MethodGet, x.url(key, ""), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.check(resp, http.MethodGet, key); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (x *S3) Open(key string) (io.ReadCloser, error) {
+
+	resp, err := x.do(http.MethodGet, x.url(key, ""), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.check(resp, http.MethodGet, key); err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (x *S3) Put(key string, data []byte) error {
+
+	resp, err := x.do(http.MethodPut, x.url(key, ""), data)
+	if err != nil {
+		return err
+	}
+	if err := x.check(resp, http.MethodPut, key); err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+func (x *S3) Stat(key string) (*Info, error) {
+
+	resp, err := x.do(http.MethodHead, x.url(key, ""), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.check(resp, http.MethodHead, key); err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &Info{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (x *S3) Delete(key string) error {
+
+	resp, err := x.do(http.MethodDelete, x.url(key, ""), nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil
+	}
+	if err := x.check(resp, http.MethodDelete, key); err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string `xml:"Key"`
+		Size         int64  `xml:"Size"`
+		LastModified string `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// List ListObjectsV2, all pages
+func (x *S3) List(prefix string) ([]*Info, error) {
+
+	res := []*Info{}
+	token := ""
+
+	for {
+		query := "list-type=2&prefix=" + uriEncode(x.opts.Prefix+prefix, true)
+		if token != "" {
+			query = "continuation-token=" + uriEncode(token, true) + "&" + query
+		}
+
+		resp, err := x.do(http.MethodGet, x.url("", query), nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := x.check(resp, "LIST", prefix); err != nil {
+			return nil, err
+		}
+
+		page := &s3ListResult{}
+		err = xml.NewDecoder(resp.Body).Decode(page)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error s3 list %v: %v", prefix, err)
+		}
+
+		for _, v := range page.Contents {
+			modTime, _ := time.Parse(time.RFC3339, v.LastModified)
+			res = append(res, &Info{
+				Key:     strings.TrimPrefix(v.Key, x.opts.Prefix),
+				Size:    v.Size,
+				ModTime: modTime,
+			})
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return res, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Find by one List, not a HEAD per ext
+func (x *S3) Find(base string, exts []string) (*Info, error) {
+
+	list, err := x.List(findPrefix(base, exts))
+	if err != nil {
+		return nil, err
+	}
+	return findIn(list, base, exts)
+}
+
+// Local never, keys are remote
+func (x *S3) Local() (string, bool) {
+	return "", false
+}
+
+// sign request by AWS Signature Version 4, all headers of r and host are signed
+func (x *S3) sign(r *http.Request, payloadHash string, now time.Time) {
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": r.Host}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	canonicalHeaders := strings.Builder{}
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + x.opts.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+x.opts.SecretKey), date)
+	for _, v := range []string{x.opts.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		x.opts.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode of SigV4: all but unreserved A-Z a-z 0-9 - _ . ~ are %XX, "/" too if encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+
+	res := strings.Builder{}
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			res.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			res.WriteByte(c)
+		default:
+			fmt.Fprintf(&res, "%%%02X", c)
+		}
+	}
+	return res.String()
+}
@@ -0,0 +1,160 @@
+// Package utilstorage blob storage of originals and cache files: local dir (FS) or S3-compatible (S3);
+// keys are slash separated and relative, "a-1/a-1#1.jpg"
+package utilstorage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotExist of Get and Stat if key not exists
+var ErrNotExist = fs.ErrNotExist
+
+// Info of stored object
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Name last element of key
+func (x *Info) Name() string {
+	return path.Base(x.Key)
+}
+
+type Storage interface {
+	// Get content of key, ErrNotExist
+	Get(key string) ([]byte, error)
+	// Open content of key as stream, ErrNotExist; only what is read is transferred
+	Open(key string) (io.ReadCloser, error)
+	// Put content of key, replaces, readers never see a partial object
+	Put(key string, data []byte) error
+	// Stat of key, ErrNotExist
+	Stat(key string) (*Info, error)
+	// Delete key, nil if not exists
+	Delete(key string) error
+	// List keys starting with prefix, any order
+	List(prefix string) ([]*Info, error)
+	// Find first existing key of base and one of exts, in exts order, ErrNotExist if none;
+	// one request of remote storage
+	Find(base string, exts []string) (*Info, error)
+	// Local dir of keys, ok false if not a local dir
+	Local() (dir string, ok bool)
+}
+
+// Toucher storage can set ModTime of key without rewriting it
+type Toucher interface {
+	Touch(key string, t time.Time) error
+}
+
+// LocalPath file of key if s is a local dir, "" if not
+func LocalPath(s Storage, key string) string {
+
+	dir, ok := s.Local()
+	if !ok {
+		return ""
+	}
+	return keyPath(dir, key)
+}
+
+// keyPath file of key in dir, never outside of it
+func keyPath(dir string, key string) string {
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// findPrefix common prefix of keys base+ext, one List of remote storage
+func findPrefix(base string, exts []string) string {
+
+	res := ""
+	for i, ext := range exts {
+		if i == 0 {
+			res = ext
+			continue
+		}
+		n := 0
+		for n < len(res) && n < len(ext) && res[n] == ext[n] {
+			n++
+		}
+		res = res[:n]
+	}
+	return base + res
+}
+
+// findIn first of base+ext in list, in exts order, ErrNotExist if none
+func findIn(list []*Info, base string, exts []string) (*Info, error) {
+
+	for _, ext := range exts {
+		for _, v := range list {
+			if v.Key == base+ext {
+				return v, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("find %v: %w", base, ErrNotExist)
+}
+
+// NewSeeker of r for header parsers: r as is if it seeks (fs file), else read bytes
+// are kept in memory for seeks back, reads over max bytes fail
+func NewSeeker(r io.Reader, max int64) io.ReadSeeker {
+
+	if v, ok := r.(io.ReadSeeker); ok {
+		return v
+	}
+	return &seeker{r: r, max: max}
+}
+
+type seeker struct {
+	r   io.Reader
+	max int64
+	buf bytes.Buffer // read of r
+	off int64
+	err error // of r, io.EOF at end
+}
+
+// fill buf up to size bytes of r, max
+func (x *seeker) fill(size int64) {
+
+	if n := min(size, x.max) - int64(x.buf.Len()); n > 0 && x.err == nil {
+		_, x.err = io.CopyN(&x.buf, x.r, n)
+	}
+}
+
+func (x *seeker) Read(p []byte) (int, error) {
+
+	x.fill(x.off + int64(len(p)))
+
+	if x.off >= int64(x.buf.Len()) {
+		if x.err != nil {
+			return 0, x.err
+		}
+		return 0, fmt.Errorf("error read over %d bytes", x.max)
+	}
+
+	n := copy(p, x.buf.Bytes()[x.off:])
+	x.off += int64(n)
+	return n, nil
+}
+
+// Seek from start or current, not end
+func (x *seeker) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += x.off
+	default:
+		return 0, errors.New("error seek from end of stream")
+	}
+	if offset < 0 {
+		return 0, errors.New("error seek before start")
+	}
+
+	x.off = offset
+	return offset, nil
+}
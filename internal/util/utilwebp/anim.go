@@ -0,0 +1,80 @@
+package utilwebp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+// VP8X animation flag
+const vp8xFlagAnimation = 0x02
+
+// ANMF flags: do not blend, no disposal (frames cover the canvas)
+const anmfNoBlend = 0x02
+
+// Animation frames of the same size, lossy
+type Animation struct {
+	Frames    []image.Image
+	Durations []int // ms per frame
+	LoopCount int   // 0 is infinite
+}
+
+// EncodeAll writes the animation a to w as animated WebP (extended format, VP8X ANIM ANMF),
+// Options Exif XMP ICC are not written
+func EncodeAll(w io.Writer, a *Animation, o *Options) error {
+
+	if len(a.Frames) == 0 || len(a.Durations) != len(a.Frames) {
+		return fmt.Errorf("error webp animation frames not valid")
+	}
+
+	quality := DefaultQuality
+	if o != nil && o.Quality > 0 {
+		quality = o.Quality
+	}
+
+	b := a.Frames[0].Bounds()
+	riff := newRiffWriter()
+
+	{
+		// flags, reserved, canvas width-1, height-1 (24 bit)
+		vp8x := []byte{vp8xFlagAnimation, 0, 0, 0}
+		vp8x = append(vp8x, le24(b.Dx()-1)...)
+		vp8x = append(vp8x, le24(b.Dy()-1)...)
+		riff.chunk("VP8X", vp8x)
+	}
+
+	{
+		// background BGRA, loop count
+		anim := []byte{0xff, 0xff, 0xff, 0xff}
+		anim = binary.LittleEndian.AppendUint16(anim, uint16(min(0xffff, max(0, a.LoopCount))))
+		riff.chunk("ANIM", anim)
+	}
+
+	for i, m := range a.Frames {
+		if m.Bounds().Size() != b.Size() {
+			return fmt.Errorf("error webp animation frame %v size %v", i, m.Bounds().Size())
+		}
+
+		frame, err := encodeVP8(m, quality)
+		if err != nil {
+			return err
+		}
+
+		// x/2, y/2, width-1, height-1, duration (24 bit), flags, frame data chunk
+		anmf := append(le24(0), le24(0)...)
+		anmf = append(anmf, le24(b.Dx()-1)...)
+		anmf = append(anmf, le24(b.Dy()-1)...)
+		anmf = append(anmf, le24(min(0xffffff, max(0, a.Durations[i])))...)
+		anmf = append(anmf, anmfNoBlend)
+
+		inner := &riffWriter{}
+		inner.chunk("VP8 ", frame)
+		anmf = append(anmf, inner.buf...)
+
+		riff.chunk("ANMF", anmf)
+	}
+
+	_, err := w.Write(riff.bytes())
+	return err
+}
@@ -0,0 +1,71 @@
+package utilwebp
+
+import "math/bits"
+
+// boolEncoder is the VP8 boolean entropy encoder, section 7 of RFC 6386.
+// It mirrors vp8_encode_bool of the reference encoder.
+type boolEncoder struct {
+	buf      []byte
+	rng      uint32 // range, 128..255 between calls
+	lowValue uint32
+	count    int
+}
+
+func newBoolEncoder(capHint int) *boolEncoder {
+	return &boolEncoder{
+		buf:   make([]byte, 0, capHint),
+		rng:   255,
+		count: -24,
+	}
+}
+
+// putBit writes bit whose probability of being 0 is prob/256.
+func (e *boolEncoder) putBit(bit bool, prob uint8) {
+	split := 1 + (((e.rng - 1) * uint32(prob)) >> 8)
+	if bit {
+		e.lowValue += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+
+	shift := bits.LeadingZeros8(uint8(e.rng))
+	e.rng <<= uint(shift)
+	e.count += shift
+
+	if e.count >= 0 {
+		offset := shift - e.count
+		if (e.lowValue<<uint(offset-1))&0x80000000 != 0 {
+			// propagate carry
+			x := len(e.buf) - 1
+			for x >= 0 && e.buf[x] == 0xff {
+				e.buf[x] = 0
+				x--
+			}
+			e.buf[x]++
+		}
+		e.buf = append(e.buf, byte(e.lowValue>>uint(24-offset)))
+		e.lowValue <<= uint(offset)
+		shift = e.count
+		e.lowValue &= 0xffffff
+		e.count -= 8
+	}
+
+	e.lowValue <<= uint(shift)
+}
+
+// putLiteral writes the n low bits of v, most significant first, at even odds.
+func (e *boolEncoder) putLiteral(v uint32, n int) {
+	for n > 0 {
+		n--
+		e.putBit((v>>uint(n))&1 == 1, 128)
+	}
+}
+
+// bytes flushes the encoder and returns the written data.
+func (e *boolEncoder) bytes() []byte {
+	for i := 0; i < 32; i++ {
+		e.putBit(false, 128)
+	}
+	return e.buf
+}
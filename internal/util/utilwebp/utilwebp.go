@@ -0,0 +1,111 @@
+// Package utilwebp lossy WebP encoder (the x/image/webp package only decodes)
+package utilwebp
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// DefaultQuality is the default quality encoding parameter.
+const DefaultQuality = 75
+
+// Options are the encoding parameters.
+// Quality ranges from 1 to 100 inclusive, higher is better.
+type Options struct {
+	Quality int
+
+	Exif []byte // TIFF structure, written with extended format (VP8X)
+	XMP  []byte // XMP packet, written with extended format (VP8X)
+	ICC  []byte // colour profile, written with extended format (VP8X)
+}
+
+// VP8X feature flags
+const (
+	vp8xFlagXMP  = 0x04
+	vp8xFlagExif = 0x08
+	vp8xFlagICC  = 0x20
+)
+
+// Encode writes the Image m to w in lossy WebP format with the given options.
+// Default parameters are used if a nil *Options is passed.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	quality := DefaultQuality
+	if o != nil && o.Quality > 0 {
+		quality = o.Quality
+	}
+
+	frame, err := encodeVP8(m, quality)
+	if err != nil {
+		return err
+	}
+
+	riff := newRiffWriter()
+
+	var exif, xmp, icc []byte
+	if o != nil {
+		exif, xmp, icc = o.Exif, o.XMP, o.ICC
+	}
+
+	if len(exif) > 0 || len(xmp) > 0 || len(icc) > 0 {
+		flags := byte(0)
+		if len(icc) > 0 {
+			flags |= vp8xFlagICC
+		}
+		if len(exif) > 0 {
+			flags |= vp8xFlagExif
+		}
+		if len(xmp) > 0 {
+			flags |= vp8xFlagXMP
+		}
+		// flags, reserved, canvas width-1, height-1 (24 bit)
+		b := m.Bounds()
+		vp8x := []byte{flags, 0, 0, 0}
+		vp8x = append(vp8x, le24(b.Dx()-1)...)
+		vp8x = append(vp8x, le24(b.Dy()-1)...)
+		riff.chunk("VP8X", vp8x)
+	}
+
+	if len(icc) > 0 {
+		riff.chunk("ICCP", icc) // before image data
+	}
+
+	riff.chunk("VP8 ", frame)
+
+	if len(exif) > 0 {
+		riff.chunk("EXIF", exif)
+	}
+	if len(xmp) > 0 {
+		riff.chunk("XMP ", xmp)
+	}
+
+	_, err = w.Write(riff.bytes())
+	return err
+}
+
+func le24(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
+
+// riffWriter builds a "RIFF....WEBP" container in memory.
+type riffWriter struct {
+	buf []byte
+}
+
+func newRiffWriter() *riffWriter {
+	return &riffWriter{buf: []byte("RIFF\x00\x00\x00\x00WEBP")}
+}
+
+func (x *riffWriter) chunk(fourCC string, data []byte) {
+	x.buf = append(x.buf, fourCC...)
+	x.buf = binary.LittleEndian.AppendUint32(x.buf, uint32(len(data)))
+	x.buf = append(x.buf, data...)
+	if len(data)%2 == 1 {
+		x.buf = append(x.buf, 0) // chunks are padded to even size
+	}
+}
+
+func (x *riffWriter) bytes() []byte {
+	binary.LittleEndian.PutUint32(x.buf[4:8], uint32(len(x.buf)-8))
+	return x.buf
+}
@@ -0,0 +1,192 @@
+package utilwebp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"go-image/internal/util/utiltest"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"math"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// psnr compares m with the decoded webp; the decoder returns the limited
+// range Y'CbCr planes as is, so convert them the way browsers do.
+func psnr(t *testing.T, m image.Image, decoded image.Image) float64 {
+	t.Helper()
+
+	ycc, ok := decoded.(*image.YCbCr)
+	if !ok {
+		t.Fatalf("decoded type %T", decoded)
+	}
+
+	b := m.Bounds()
+	if ycc.Bounds().Dx() != b.Dx() || ycc.Bounds().Dy() != b.Dy() {
+		t.Fatalf("size mismatch %v != %v", ycc.Bounds(), b)
+	}
+
+	var sse float64
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			yy := float64(ycc.Y[ycc.YOffset(x, y)]) - 16
+			cb := float64(ycc.Cb[ycc.COffset(x, y)]) - 128
+			cr := float64(ycc.Cr[ycc.COffset(x, y)]) - 128
+			r := 1.164*yy + 1.596*cr
+			g := 1.164*yy - 0.392*cb - 0.813*cr
+			bl := 1.164*yy + 2.017*cb
+
+			sr, sg, sb, _ := m.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			for _, d := range []float64{r - float64(sr>>8), g - float64(sg>>8), bl - float64(sb>>8)} {
+				sse += d * d
+			}
+		}
+	}
+	mse := sse / float64(b.Dx()*b.Dy()*3)
+	return 10 * math.Log10(255*255/mse)
+}
+
+func TestEncode(t *testing.T) {
+
+	src, _, err := image.Decode(bytes.NewReader(utiltest.GetTestImage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gradient := image.NewRGBA(image.Rect(0, 0, 37, 21)) // not multiple of 16
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			gradient.Set(x, y, color.RGBA{uint8(x * 6), uint8(y * 12), uint8(255 - x*3), 255})
+		}
+	}
+
+	tests := []struct {
+		name    string
+		img     image.Image
+		quality int
+		minPSNR float64
+	}{
+		{"photo q75", src, 75, 30},
+		{"photo q95", src, 95, 34},
+		{"photo q10", src, 10, 22},
+		{"gradient", gradient, 75, 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := Encode(buf, tt.img, &Options{Quality: tt.quality}); err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if v := psnr(t, tt.img, decoded); v < tt.minPSNR {
+				t.Errorf("psnr %.2f < %.2f", v, tt.minPSNR)
+			} else {
+				t.Logf("size %d psnr %.2f", buf.Len(), v)
+			}
+		})
+	}
+}
+
+func TestQualityToIndex(t *testing.T) {
+	prev := 128
+	for q := 1; q <= 100; q++ {
+		qi := qualityToIndex(q)
+		if qi < 0 || qi > 127 || qi > prev {
+			t.Fatalf("quality %d index %d prev %d", q, qi, prev)
+		}
+		prev = qi
+	}
+}
+
+func TestEncodeMetadata(t *testing.T) {
+
+	m := image.NewRGBA(image.Rect(0, 0, 33, 17))
+	exif := []byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00")
+	xmp := []byte("<x:xmpmeta/>")
+	icc := []byte("icc")
+
+	b := &bytes.Buffer{}
+	if err := Encode(b, m, &Options{Exif: exif, XMP: xmp, ICC: icc}); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+
+	if string(data[12:16]) != "VP8X" || data[20] != vp8xFlagExif|vp8xFlagXMP|vp8xFlagICC {
+		t.Fatalf("no VP8X header: %q", data[12:21])
+	}
+	if !bytes.Contains(data, append([]byte("EXIF\x0d\x00\x00\x00"), exif...)) || !bytes.Contains(data, append([]byte("XMP \x0c\x00\x00\x00"), xmp...)) {
+		t.Fatal("EXIF or XMP chunk missing")
+	}
+	if string(data[30:42]) != "ICCP\x03\x00\x00\x00icc\x00" {
+		t.Fatalf("ICCP chunk must follow VP8X: %q", data[30:42])
+	}
+
+	cfg, err := webp.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 33 || cfg.Height != 17 {
+		t.Fatalf("canvas %vx%v", cfg.Width, cfg.Height)
+	}
+	if _, err := webp.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeAll(t *testing.T) {
+
+	frames := []image.Image{}
+	for i := range 3 {
+		m := image.NewRGBA(image.Rect(0, 0, 33, 17))
+		for j := range m.Pix {
+			m.Pix[j] = uint8(i * 100)
+		}
+		frames = append(frames, m)
+	}
+
+	b := &bytes.Buffer{}
+	if err := EncodeAll(b, &Animation{Frames: frames, Durations: []int{100, 200, 300}, LoopCount: 0}, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+
+	if string(data[12:16]) != "VP8X" || data[20] != vp8xFlagAnimation || string(data[30:34]) != "ANIM" {
+		t.Fatalf("no VP8X ANIM header: %q", data[12:34])
+	}
+	if binary.LittleEndian.Uint32(data[4:8]) != uint32(len(data)-8) {
+		t.Fatal("riff size")
+	}
+
+	// ANMF chunks with durations
+	count := 0
+	for i := 30 + 8 + 6; i+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if string(data[i:i+4]) != "ANMF" {
+			t.Fatalf("chunk %q", data[i:i+4])
+		}
+		payload := data[i+8:]
+		if d := int(payload[12]) | int(payload[13])<<8 | int(payload[14])<<16; d != 100*(count+1) {
+			t.Fatalf("frame %v duration %v", count, d)
+		}
+		if string(payload[16:20]) != "VP8 " {
+			t.Fatalf("frame %v data %q", count, payload[16:20])
+		}
+		count++
+		i += 8 + size + size%2
+	}
+	if count != 3 {
+		t.Fatalf("frames %v", count)
+	}
+
+	if err := EncodeAll(b, &Animation{Frames: frames, Durations: []int{100}}, nil); err == nil {
+		t.Fatal("durations must match frames")
+	}
+}
@@ -0,0 +1,834 @@
+package utilwebp
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// This file implements a key frame only VP8 encoder (RFC 6386).
+// Every macroblock uses 16x16 luma and 8x8 chroma intra prediction
+// with the mode picked by the lowest prediction error.
+
+const (
+	maxFirstPartition = 1<<19 - 1 // 19 bits in the frame tag
+	maxDimension      = 1<<14 - 1 // 14 bits in the key frame header
+)
+
+// nzContext holds "has non-zero coefficients" flags of the bottom (top
+// context) or right (left context) 4x4 blocks of a macroblock.
+type nzContext struct {
+	y  [4]uint8
+	u  [2]uint8
+	v  [2]uint8
+	y2 uint8
+}
+
+// quantMatrix DC/AC quantizers of the y1, y2 and uv planes.
+type quantMatrix struct {
+	y1 [2]int32
+	y2 [2]int32
+	uv [2]int32
+}
+
+type vp8Encoder struct {
+	width, height int
+	mbw, mbh      int
+
+	// source planes, padded to whole macroblocks
+	srcY, srcU, srcV []uint8
+	// reconstructed planes, what the decoder will see
+	recY, recU, recV []uint8
+	yStride          int
+	uvStride         int
+
+	qi    int
+	quant quantMatrix
+
+	modesY  []uint8
+	modesUV []uint8
+	skips   []bool
+
+	topNz  []nzContext
+	leftNz nzContext
+
+	tokens *boolEncoder
+}
+
+// qualityToIndex maps quality 1..100 to the quantizer index 0..127,
+// following the libwebp quality curve.
+func qualityToIndex(quality int) int {
+	if quality < 1 {
+		quality = 1
+	}
+	if quality > 100 {
+		quality = 100
+	}
+	c := float64(quality) / 100
+	var linear float64
+	if c < 0.75 {
+		linear = c * (2.0 / 3.0)
+	} else {
+		linear = 2*c - 1
+	}
+	v := math.Pow(linear, 1.0/3.0)
+	qi := int(127*(1-v) + 0.5)
+	return min(max(qi, 0), 127)
+}
+
+func newQuantMatrix(qi int) quantMatrix {
+	q := quantMatrix{}
+	q.y1[0] = int32(dequantTableDC[qi])
+	q.y1[1] = int32(dequantTableAC[qi])
+	q.y2[0] = int32(dequantTableDC[qi]) * 2
+	q.y2[1] = int32(dequantTableAC[qi]) * 155 / 100
+	if q.y2[1] < 8 {
+		q.y2[1] = 8
+	}
+	q.uv[0] = int32(dequantTableDC[min(qi, 117)])
+	q.uv[1] = int32(dequantTableAC[qi])
+	return q
+}
+
+// encodeVP8 returns the payload of the "VP8 " chunk.
+func encodeVP8(m image.Image, quality int) ([]byte, error) {
+	b := m.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > maxDimension || b.Dy() > maxDimension {
+		return nil, fmt.Errorf("webp: invalid image size %dx%d", b.Dx(), b.Dy())
+	}
+
+	e := &vp8Encoder{
+		width:  b.Dx(),
+		height: b.Dy(),
+	}
+	e.mbw = (e.width + 15) / 16
+	e.mbh = (e.height + 15) / 16
+	e.yStride = e.mbw * 16
+	e.uvStride = e.mbw * 8
+	e.qi = qualityToIndex(quality)
+	e.quant = newQuantMatrix(e.qi)
+
+	e.importImage(m)
+
+	e.recY = make([]uint8, len(e.srcY))
+	e.recU = make([]uint8, len(e.srcU))
+	e.recV = make([]uint8, len(e.srcV))
+	e.modesY = make([]uint8, e.mbw*e.mbh)
+	e.modesUV = make([]uint8, e.mbw*e.mbh)
+	e.skips = make([]bool, e.mbw*e.mbh)
+	e.topNz = make([]nzContext, e.mbw)
+	e.tokens = newBoolEncoder(e.width * e.height / 4)
+
+	for mby := 0; mby < e.mbh; mby++ {
+		e.leftNz = nzContext{}
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+
+	first := e.firstPartition()
+	if len(first) > maxFirstPartition {
+		return nil, fmt.Errorf("webp: first partition too large: %d", len(first))
+	}
+	tokens := e.tokens.bytes()
+
+	out := make([]byte, 0, 10+len(first)+len(tokens))
+	tag := uint32(len(first))<<5 | 1<<4 // key frame, version 0, show frame
+	out = append(out,
+		byte(tag), byte(tag>>8), byte(tag>>16),
+		0x9d, 0x01, 0x2a,
+		byte(e.width), byte(e.width>>8),
+		byte(e.height), byte(e.height>>8),
+	)
+	out = append(out, first...)
+	out = append(out, tokens...)
+	return out, nil
+}
+
+// importImage converts m to padded Y'CbCr planes (BT.601 limited range as libwebp).
+func (e *vp8Encoder) importImage(m image.Image) {
+	b := m.Bounds()
+	pw, ph := e.mbw*16, e.mbh*16
+
+	rgb := make([]uint8, pw*ph*3)
+	e.srcY = make([]uint8, pw*ph)
+	for y := 0; y < ph; y++ {
+		sy := b.Min.Y + min(y, e.height-1)
+		for x := 0; x < pw; x++ {
+			sx := b.Min.X + min(x, e.width-1)
+			r, g, bb := pixelRGB(m, sx, sy)
+			i := y*pw + x
+			rgb[i*3], rgb[i*3+1], rgb[i*3+2] = uint8(r), uint8(g), uint8(bb)
+			e.srcY[i] = uint8((16839*r + 33059*g + 6420*bb + 1<<15 + 16<<16) >> 16)
+		}
+	}
+
+	cw, ch := pw/2, ph/2
+	e.srcU = make([]uint8, cw*ch)
+	e.srcV = make([]uint8, cw*ch)
+	for y := 0; y < ch; y++ {
+		for x := 0; x < cw; x++ {
+			var r, g, bb int32
+			for _, o := range [4]int{0, 1, pw, pw + 1} {
+				i := ((2*y)*pw + 2*x + o) * 3
+				r += int32(rgb[i])
+				g += int32(rgb[i+1])
+				bb += int32(rgb[i+2])
+			}
+			e.srcU[y*cw+x] = clipUV(-9719*r - 19081*g + 28800*bb)
+			e.srcV[y*cw+x] = clipUV(28800*r - 24116*g - 4684*bb)
+		}
+	}
+}
+
+// clipUV scales a chroma value computed from the sum of 4 pixels.
+func clipUV(uv int32) uint8 {
+	uv = (uv + 1<<17 + 128<<18) >> 18
+	return clip8(uv)
+}
+
+// pixelRGB returns the non-premultiplied 8-bit colour of a pixel.
+func pixelRGB(m image.Image, x, y int) (r, g, b int32) {
+	switch img := m.(type) {
+	case *image.RGBA:
+		i := img.PixOffset(x, y)
+		p := img.Pix[i : i+4 : i+4]
+		return unpremultiply(p[0], p[3]), unpremultiply(p[1], p[3]), unpremultiply(p[2], p[3])
+	case *image.NRGBA:
+		i := img.PixOffset(x, y)
+		p := img.Pix[i : i+3 : i+3]
+		return int32(p[0]), int32(p[1]), int32(p[2])
+	case *image.YCbCr:
+		r, g, b := color.YCbCrToRGB(img.Y[img.YOffset(x, y)], img.Cb[img.COffset(x, y)], img.Cr[img.COffset(x, y)])
+		return int32(r), int32(g), int32(b)
+	}
+	cr, cg, cb, ca := m.At(x, y).RGBA()
+	if ca == 0 {
+		return 0, 0, 0
+	}
+	if ca != 0xffff {
+		cr = cr * 0xffff / ca
+		cg = cg * 0xffff / ca
+		cb = cb * 0xffff / ca
+	}
+	return int32(cr >> 8), int32(cg >> 8), int32(cb >> 8)
+}
+
+func unpremultiply(c, a uint8) int32 {
+	if a == 0xff {
+		return int32(c)
+	}
+	if a == 0 {
+		return 0
+	}
+	return min(int32(c)*255/int32(a), 255)
+}
+
+func clip8(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// border collects the reconstructed top row, left column and top-left
+// corner samples around a block, using the decoder's edge values.
+func border(rec []uint8, stride, bx, by, size int, top, left []uint8) (corner uint8) {
+	x0, y0 := bx*size, by*size
+	if by == 0 {
+		for i := range top[:size] {
+			top[i] = 0x7f
+		}
+		corner = 0x7f
+	} else {
+		copy(top[:size], rec[(y0-1)*stride+x0:])
+		if bx == 0 {
+			corner = 0x81
+		} else {
+			corner = rec[(y0-1)*stride+x0-1]
+		}
+	}
+	if bx == 0 {
+		for j := range left[:size] {
+			left[j] = 0x81
+		}
+	} else {
+		for j := 0; j < size; j++ {
+			left[j] = rec[(y0+j)*stride+x0-1]
+		}
+	}
+	return corner
+}
+
+// predict fills pred (size x size) for the given mode.
+func predict(pred []uint8, mode int, size, bx, by int, top, left []uint8, corner uint8) {
+	switch mode {
+	case predDC:
+		shift := 3
+		if size == 16 {
+			shift = 4
+		}
+		var sum int
+		var dc uint8
+		switch {
+		case bx == 0 && by == 0:
+			dc = 0x80
+		case by == 0: // left only
+			for _, v := range left[:size] {
+				sum += int(v)
+			}
+			dc = uint8((sum + size/2) >> shift)
+		case bx == 0: // top only
+			for _, v := range top[:size] {
+				sum += int(v)
+			}
+			dc = uint8((sum + size/2) >> shift)
+		default:
+			for i := 0; i < size; i++ {
+				sum += int(top[i]) + int(left[i])
+			}
+			dc = uint8((sum + size) >> (shift + 1))
+		}
+		for i := range pred[:size*size] {
+			pred[i] = dc
+		}
+	case predTM:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				pred[j*size+i] = clip8(int32(left[j]) + int32(top[i]) - int32(corner))
+			}
+		}
+	case predVE:
+		for j := 0; j < size; j++ {
+			copy(pred[j*size:j*size+size], top[:size])
+		}
+	case predHE:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				pred[j*size+i] = left[j]
+			}
+		}
+	}
+}
+
+// bestPrediction picks the mode with the lowest squared error and leaves
+// its prediction in pred.
+func bestPrediction(pred []uint8, src []uint8, stride, size, bx, by int, top, left []uint8, corner uint8) int {
+	tmp := make([]uint8, size*size)
+	best, bestErr := predDC, int64(math.MaxInt64)
+	for mode := 0; mode < nPredMode; mode++ {
+		predict(tmp, mode, size, bx, by, top, left, corner)
+		var sse int64
+		for j := 0; j < size; j++ {
+			row := src[(by*size+j)*stride+bx*size:]
+			for i := 0; i < size; i++ {
+				d := int64(row[i]) - int64(tmp[j*size+i])
+				sse += d * d
+			}
+		}
+		if sse < bestErr {
+			best, bestErr = mode, sse
+			copy(pred, tmp)
+		}
+	}
+	return best
+}
+
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) {
+	var (
+		top, left [16]uint8
+		predY     [256]uint8
+		predU     [64]uint8
+		predV     [64]uint8
+		y1        [16][16]int16 // quantized luma levels, raster order per block
+		y2        [16]int16
+		uv        [8][16]int16 // 4 U then 4 V blocks
+	)
+
+	// luma
+	corner := border(e.recY, e.yStride, mbx, mby, 16, top[:], left[:])
+	modeY := bestPrediction(predY[:], e.srcY, e.yStride, 16, mbx, mby, top[:], left[:], corner)
+
+	var dc [16]int32
+	for n := 0; n < 16; n++ {
+		bx, by := n%4*4, n/4*4
+		var coeffs [16]int32
+		fTransform(e.srcY[(mby*16+by)*e.yStride+mbx*16+bx:], e.yStride, predY[by*16+bx:], 16, &coeffs)
+		dc[n] = coeffs[0]
+		for i := 1; i < 16; i++ {
+			y1[n][i] = quantize(coeffs[i], e.quant.y1[1])
+		}
+	}
+	var whtCoeffs [16]int32
+	fTransformWHT(&dc, &whtCoeffs)
+	for i := 0; i < 16; i++ {
+		y2[i] = quantize(whtCoeffs[i], e.quant.y2[min(i, 1)])
+	}
+
+	// chroma
+	corner = border(e.recU, e.uvStride, mbx, mby, 8, top[:], left[:])
+	modeUV := bestPredictionUV(e, mbx, mby, predU[:], predV[:], top[:], left[:], corner)
+	for n := 0; n < 8; n++ {
+		src, pred := e.srcU, predU[:]
+		if n >= 4 {
+			src, pred = e.srcV, predV[:]
+		}
+		bx, by := n%2*4, n%4/2*4
+		var coeffs [16]int32
+		fTransform(src[(mby*8+by)*e.uvStride+mbx*8+bx:], e.uvStride, pred[by*8+bx:], 8, &coeffs)
+		for i := 0; i < 16; i++ {
+			uv[n][i] = quantize(coeffs[i], e.quant.uv[min(i, 1)])
+		}
+	}
+
+	skip := isZero(y2[:])
+	for n := 0; n < 16 && skip; n++ {
+		skip = isZero(y1[n][:])
+	}
+	for n := 0; n < 8 && skip; n++ {
+		skip = isZero(uv[n][:])
+	}
+
+	i := mby*e.mbw + mbx
+	e.modesY[i] = uint8(modeY)
+	e.modesUV[i] = uint8(modeUV)
+	e.skips[i] = skip
+
+	if skip {
+		e.leftNz = nzContext{}
+		e.topNz[mbx] = nzContext{}
+	} else {
+		e.writeTokens(mbx, &y2, &y1, &uv)
+	}
+
+	e.reconstruct(mbx, mby, &predY, &predU, &predV, &y2, &y1, &uv)
+}
+
+func bestPredictionUV(e *vp8Encoder, mbx, mby int, predU, predV []uint8, topU, leftU []uint8, cornerU uint8) int {
+	var topV, leftV [8]uint8
+	cornerV := border(e.recV, e.uvStride, mbx, mby, 8, topV[:], leftV[:])
+
+	var tmpU, tmpV [64]uint8
+	best, bestErr := predDC, int64(math.MaxInt64)
+	for mode := 0; mode < nPredMode; mode++ {
+		predict(tmpU[:], mode, 8, mbx, mby, topU, leftU, cornerU)
+		predict(tmpV[:], mode, 8, mbx, mby, topV[:], leftV[:], cornerV)
+		var sse int64
+		for j := 0; j < 8; j++ {
+			o := (mby*8+j)*e.uvStride + mbx*8
+			for i := 0; i < 8; i++ {
+				du := int64(e.srcU[o+i]) - int64(tmpU[j*8+i])
+				dv := int64(e.srcV[o+i]) - int64(tmpV[j*8+i])
+				sse += du*du + dv*dv
+			}
+		}
+		if sse < bestErr {
+			best, bestErr = mode, sse
+			copy(predU, tmpU[:])
+			copy(predV, tmpV[:])
+		}
+	}
+	return best
+}
+
+func isZero(levels []int16) bool {
+	for _, v := range levels {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// quantize returns the level of coefficient c, with a small dead zone.
+func quantize(c int32, q int32) int16 {
+	sign := int32(1)
+	if c < 0 {
+		sign, c = -1, -c
+	}
+	level := (c + q*3/8) / q
+	if level > 2047 {
+		level = 2047
+	}
+	return int16(sign * level)
+}
+
+// fTransform is the forward 4x4 DCT of src-pred, as in libwebp.
+func fTransform(src []uint8, srcStride int, pred []uint8, predStride int, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		s := src[i*srcStride:]
+		p := pred[i*predStride:]
+		d0 := int32(s[0]) - int32(p[0])
+		d1 := int32(s[1]) - int32(p[1])
+		d2 := int32(s[2]) - int32(p[2])
+		d3 := int32(s[3]) - int32(p[3])
+		a0 := d0 + d3
+		a1 := d1 + d2
+		a2 := d1 - d2
+		a3 := d0 - d3
+		tmp[0+i*4] = (a0 + a1) * 8
+		tmp[1+i*4] = (a2*2217 + a3*5352 + 1812) >> 9
+		tmp[2+i*4] = (a0 - a1) * 8
+		tmp[3+i*4] = (a3*2217 - a2*5352 + 937) >> 9
+	}
+	for i := 0; i < 4; i++ {
+		a0 := tmp[0+i] + tmp[12+i]
+		a1 := tmp[4+i] + tmp[8+i]
+		a2 := tmp[4+i] - tmp[8+i]
+		a3 := tmp[0+i] - tmp[12+i]
+		out[0+i] = (a0 + a1 + 7) >> 4
+		out[4+i] = (a2*2217 + a3*5352 + 12000) >> 16
+		if a3 != 0 {
+			out[4+i]++
+		}
+		out[8+i] = (a0 - a1 + 7) >> 4
+		out[12+i] = (a3*2217 - a2*5352 + 51000) >> 16
+	}
+}
+
+// fTransformWHT is the forward Walsh-Hadamard transform of the 16 luma DCs.
+func fTransformWHT(in *[16]int32, out *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		r := in[i*4:]
+		a0 := r[0] + r[2]
+		a1 := r[1] + r[3]
+		a2 := r[1] - r[3]
+		a3 := r[0] - r[2]
+		tmp[0+i*4] = a0 + a1
+		tmp[1+i*4] = a3 + a2
+		tmp[2+i*4] = a3 - a2
+		tmp[3+i*4] = a0 - a1
+	}
+	for i := 0; i < 4; i++ {
+		a0 := tmp[0+i] + tmp[8+i]
+		a1 := tmp[4+i] + tmp[12+i]
+		a2 := tmp[4+i] - tmp[12+i]
+		a3 := tmp[0+i] - tmp[8+i]
+		out[0+i] = (a0 + a1) >> 1
+		out[4+i] = (a3 + a2) >> 1
+		out[8+i] = (a3 - a2) >> 1
+		out[12+i] = (a0 - a1) >> 1
+	}
+}
+
+// iTransformWHT is the decoder's inverse WHT; dc receives the DC of each block.
+func iTransformWHT(in *[16]int32, dc *[16]int32) {
+	var m [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[0+i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[0+i] - in[12+i]
+		m[0+i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		d := m[0+i*4] + 3
+		a0 := d + m[3+i*4]
+		a1 := m[1+i*4] + m[2+i*4]
+		a2 := m[1+i*4] - m[2+i*4]
+		a3 := d - m[3+i*4]
+		dc[i*4+0] = (a0 + a1) >> 3
+		dc[i*4+1] = (a3 + a2) >> 3
+		dc[i*4+2] = (a0 - a1) >> 3
+		dc[i*4+3] = (a3 - a2) >> 3
+	}
+}
+
+// iTransformAdd is the decoder's inverse DCT, added to the block at dst.
+func iTransformAdd(in *[16]int32, dst []uint8, stride int) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2).
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2).
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := in[0+i] + in[8+i]
+		b := in[0+i] - in[8+i]
+		c := (in[4+i]*c2)>>16 - (in[12+i]*c1)>>16
+		d := (in[4+i]*c1)>>16 + (in[12+i]*c2)>>16
+		m[i][0] = a + d
+		m[i][1] = b + c
+		m[i][2] = b - c
+		m[i][3] = a - d
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		row := dst[j*stride:]
+		row[0] = clip8(int32(row[0]) + (a+d)>>3)
+		row[1] = clip8(int32(row[1]) + (b+c)>>3)
+		row[2] = clip8(int32(row[2]) + (b-c)>>3)
+		row[3] = clip8(int32(row[3]) + (a-d)>>3)
+	}
+}
+
+// reconstruct writes prediction plus dequantized residuals to the rec planes.
+func (e *vp8Encoder) reconstruct(mbx, mby int, predY *[256]uint8, predU, predV *[64]uint8,
+	y2 *[16]int16, y1 *[16][16]int16, uv *[8][16]int16) {
+
+	var in, dc [16]int32
+	for i := 0; i < 16; i++ {
+		in[i] = int32(y2[i]) * e.quant.y2[min(i, 1)]
+	}
+	iTransformWHT(&in, &dc)
+
+	for j := 0; j < 16; j++ {
+		copy(e.recY[(mby*16+j)*e.yStride+mbx*16:], predY[j*16:j*16+16])
+	}
+	for n := 0; n < 16; n++ {
+		in[0] = dc[n]
+		for i := 1; i < 16; i++ {
+			in[i] = int32(y1[n][i]) * e.quant.y1[1]
+		}
+		bx, by := n%4*4, n/4*4
+		iTransformAdd(&in, e.recY[(mby*16+by)*e.yStride+mbx*16+bx:], e.yStride)
+	}
+
+	for j := 0; j < 8; j++ {
+		o := (mby*8+j)*e.uvStride + mbx*8
+		copy(e.recU[o:], predU[j*8:j*8+8])
+		copy(e.recV[o:], predV[j*8:j*8+8])
+	}
+	for n := 0; n < 8; n++ {
+		rec := e.recU
+		if n >= 4 {
+			rec = e.recV
+		}
+		for i := 0; i < 16; i++ {
+			in[i] = int32(uv[n][i]) * e.quant.uv[min(i, 1)]
+		}
+		bx, by := n%2*4, n%4/2*4
+		iTransformAdd(&in, rec[(mby*8+by)*e.uvStride+mbx*8+bx:], e.uvStride)
+	}
+}
+
+// writeTokens codes the residuals of a macroblock into the token partition.
+func (e *vp8Encoder) writeTokens(mbx int, y2 *[16]int16, y1 *[16][16]int16, uv *[8][16]int16) {
+	top := &e.topNz[mbx]
+	left := &e.leftNz
+
+	nz := e.writeBlock(planeY2, top.y2+left.y2, y2[:], 0)
+	top.y2, left.y2 = nz, nz
+
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			nz = e.writeBlock(planeY1WithY2, top.y[x]+left.y[y], y1[y*4+x][:], 1)
+			top.y[x], left.y[y] = nz, nz
+		}
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			nz = e.writeBlock(planeUV, top.u[x]+left.u[y], uv[y*2+x][:], 0)
+			top.u[x], left.u[y] = nz, nz
+		}
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			nz = e.writeBlock(planeUV, top.v[x]+left.v[y], uv[4+y*2+x][:], 0)
+			top.v[x], left.v[y] = nz, nz
+		}
+	}
+}
+
+// writeBlock codes one 4x4 block of levels (raster order) starting at scan
+// position first and returns 1 if anything but an immediate EOB was written.
+func (e *vp8Encoder) writeBlock(plane int, ctx uint8, levels []int16, first int) uint8 {
+	w := e.tokens
+	probs := &defaultTokenProb[plane]
+
+	last := -1
+	for i := 15; i >= first; i-- {
+		if levels[zigzag[i]] != 0 {
+			last = i
+			break
+		}
+	}
+
+	p := &probs[bands[first]][ctx]
+	if last < 0 {
+		w.putBit(false, p[0])
+		return 0
+	}
+	w.putBit(true, p[0])
+
+	for i := first; i < 16; {
+		v := int32(levels[zigzag[i]])
+		i++
+		if v == 0 {
+			w.putBit(false, p[1])
+			p = &probs[bands[i]][0]
+			continue
+		}
+		w.putBit(true, p[1])
+
+		sign := v < 0
+		if sign {
+			v = -v
+		}
+		writeLevel(w, p, v)
+		if v == 1 {
+			p = &probs[bands[i]][1]
+		} else {
+			p = &probs[bands[i]][2]
+		}
+		w.putBit(sign, 128)
+
+		if i == 16 {
+			break
+		}
+		if i > last {
+			w.putBit(false, p[0]) // EOB
+			break
+		}
+		w.putBit(true, p[0])
+	}
+	return 1
+}
+
+// writeLevel codes a non-zero magnitude with the token tree of section 13.2.
+func writeLevel(w *boolEncoder, p *[nProb]uint8, v int32) {
+	if v == 1 {
+		w.putBit(false, p[2])
+		return
+	}
+	w.putBit(true, p[2])
+	if v <= 4 {
+		w.putBit(false, p[3])
+		if v == 2 {
+			w.putBit(false, p[4])
+		} else {
+			w.putBit(true, p[4])
+			w.putBit(v == 4, p[5])
+		}
+		return
+	}
+	w.putBit(true, p[3])
+	if v <= 10 {
+		w.putBit(false, p[6])
+		if v <= 6 {
+			w.putBit(false, p[7])
+			w.putBit(v == 6, 159) // category 1
+		} else {
+			w.putBit(true, p[7])
+			v -= 7 // category 2
+			w.putBit(v&2 != 0, 165)
+			w.putBit(v&1 != 0, 145)
+		}
+		return
+	}
+	w.putBit(true, p[6])
+	var cat int
+	switch {
+	case v < 19:
+		cat = 0
+	case v < 35:
+		cat = 1
+	case v < 67:
+		cat = 2
+	default:
+		cat = 3
+	}
+	w.putBit(cat >= 2, p[8])
+	w.putBit(cat&1 == 1, p[9+cat/2])
+
+	tab := &cat3456[cat]
+	n := 0
+	for tab[n] != 0 {
+		n++
+	}
+	extra := v - 3 - (8 << uint(cat))
+	for i := 0; i < n; i++ {
+		w.putBit((extra>>uint(n-1-i))&1 == 1, tab[i])
+	}
+}
+
+// firstPartition writes the frame header and the per macroblock modes.
+func (e *vp8Encoder) firstPartition() []byte {
+	w := newBoolEncoder(e.mbw*e.mbh + 1024)
+
+	w.putLiteral(0, 1) // color space
+	w.putLiteral(0, 1) // clamping type
+	w.putLiteral(0, 1) // no segmentation
+
+	w.putLiteral(0, 1) // normal loop filter
+	w.putLiteral(uint32(min(e.qi*3/8+2, 63)), 6)
+	w.putLiteral(0, 3) // sharpness
+	w.putLiteral(0, 1) // no loop filter deltas
+
+	w.putLiteral(0, 2) // one token partition
+
+	w.putLiteral(uint32(e.qi), 7)
+	for i := 0; i < 5; i++ {
+		w.putLiteral(0, 1) // no quantizer deltas
+	}
+
+	w.putLiteral(0, 1) // refresh entropy probs
+
+	for i := range tokenProbUpdateProb {
+		for j := range tokenProbUpdateProb[i] {
+			for k := range tokenProbUpdateProb[i][j] {
+				for l := range tokenProbUpdateProb[i][j][k] {
+					w.putBit(false, tokenProbUpdateProb[i][j][k][l])
+				}
+			}
+		}
+	}
+
+	nonSkip := 0
+	for _, s := range e.skips {
+		if !s {
+			nonSkip++
+		}
+	}
+	skipProb := uint8(min(max(nonSkip*256/len(e.skips), 1), 255))
+	w.putLiteral(1, 1) // mb_no_coeff_skip
+	w.putLiteral(uint32(skipProb), 8)
+
+	for i := range e.skips {
+		w.putBit(e.skips[i], skipProb)
+		w.putBit(true, 145) // 16x16 luma prediction
+		switch e.modesY[i] {
+		case predDC:
+			w.putBit(false, 156)
+			w.putBit(false, 163)
+		case predVE:
+			w.putBit(false, 156)
+			w.putBit(true, 163)
+		case predHE:
+			w.putBit(true, 156)
+			w.putBit(false, 128)
+		case predTM:
+			w.putBit(true, 156)
+			w.putBit(true, 128)
+		}
+		switch e.modesUV[i] {
+		case predDC:
+			w.putBit(false, 142)
+		case predVE:
+			w.putBit(true, 142)
+			w.putBit(false, 114)
+		case predHE:
+			w.putBit(true, 142)
+			w.putBit(true, 114)
+			w.putBit(false, 183)
+		case predTM:
+			w.putBit(true, 142)
+			w.putBit(true, 114)
+			w.putBit(true, 183)
+		}
+	}
+
+	return w.bytes()
+}
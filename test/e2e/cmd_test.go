@@ -53,6 +53,7 @@ func TestCmd(t *testing.T) {
 		{title: "test image size 1", url: "http://127.0.0.1:32180/image/api/size/test-bucket/obj-1-2-3-4/1.jpg", query: map[string]string{}},
 		{title: "test image size 3", url: "http://127.0.0.1:32180/image/api/size/test-bucket/obj-1-2-3-4/3.jpg", query: map[string]string{}},
 		{title: "test image size 6", url: "http://127.0.0.1:32180/image/api/size/test-bucket/obj-1-2-3-4/6.jpg", query: map[string]string{}},
+		{title: "test image size 2 webp", url: "http://127.0.0.1:32180/image/api/size/test-bucket/obj-1-2-3-4/2.webp", query: map[string]string{}},
 	}
 
 	for _, itm := range urls {